@@ -0,0 +1,302 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// AdaptiveLimiter 的默认参数
+const (
+	DefaultAdaptiveInitialRate = 100.0
+	DefaultAdaptiveMinRate     = 1.0
+	DefaultAdaptiveMaxRate     = 10000.0
+	DefaultAdaptiveIncrease    = 5.0
+	DefaultAdaptiveDecrease    = 0.5
+	DefaultAdaptiveCooldown    = time.Second
+)
+
+// TableRate 为AdaptiveLimiter中某张表的当前状态
+type TableRate struct {
+	TableName string
+	// Rate 为当前允许的每秒请求数
+	Rate float64
+	// Successes 和 Throttles 为成功和因能力单元不足或服务端繁忙而失败的请求数
+	Successes int64
+	Throttles int64
+	// Decreases 为降速的次数
+	Decreases    int64
+	LastDecrease time.Time
+}
+
+type adaptiveTable struct {
+	bucket       tokenBucket
+	rate         float64
+	successes    int64
+	throttles    int64
+	decreases    int64
+	lastDecrease time.Time
+}
+
+// AdaptiveLimiter 按表限制每秒请求数，并参照TCP拥塞控制调整速率（AIMD）：
+// 遇到OTSNotEnoughCapacityUnit或OTSServerBusy时速率乘以Decrease，每次成功增加Increase/当前速率，
+// 即满速运行时约每秒增加Increase。批量操作中单行返回这些错误同样会降速。
+// 需要等待时的行为与CapacityLimiter相同。
+// 示例:
+//
+//	client.AdaptiveLimiter = gots.NewAdaptiveLimiter()
+//	for _, rate := range client.AdaptiveLimiter.Rates() {
+//		fmt.Println(rate.TableName, rate.Rate)
+//	}
+type AdaptiveLimiter struct {
+	// InitialRate 为表的初始每秒请求数，为0时使用DefaultAdaptiveInitialRate
+	InitialRate float64
+	// MinRate 和 MaxRate 为速率的上下限，为0时使用DefaultAdaptiveMinRate和DefaultAdaptiveMaxRate
+	MinRate float64
+	MaxRate float64
+	// Increase 为满速运行时每秒增加的速率，为0时使用DefaultAdaptiveIncrease
+	Increase float64
+	// Decrease 为降速时速率乘以的系数，取值(0, 1)，超出范围时使用DefaultAdaptiveDecrease
+	Decrease float64
+	// Cooldown 为两次降速之间的最小间隔，避免并发请求同时失败时速率被连续削减，
+	// 为0时使用DefaultAdaptiveCooldown，小于0时不限制
+	Cooldown time.Duration
+
+	mu     sync.Mutex
+	tables map[string]*adaptiveTable
+}
+
+// NewAdaptiveLimiter 返回使用默认参数的AdaptiveLimiter
+func NewAdaptiveLimiter() *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		InitialRate: DefaultAdaptiveInitialRate,
+		MinRate:     DefaultAdaptiveMinRate,
+		MaxRate:     DefaultAdaptiveMaxRate,
+		Increase:    DefaultAdaptiveIncrease,
+		Decrease:    DefaultAdaptiveDecrease,
+		Cooldown:    DefaultAdaptiveCooldown,
+	}
+}
+
+func (l *AdaptiveLimiter) initialRate() float64 {
+	if l.InitialRate > 0 {
+		return l.InitialRate
+	}
+	return DefaultAdaptiveInitialRate
+}
+
+func (l *AdaptiveLimiter) minRate() float64 {
+	if l.MinRate > 0 {
+		return l.MinRate
+	}
+	return DefaultAdaptiveMinRate
+}
+
+func (l *AdaptiveLimiter) maxRate() float64 {
+	if l.MaxRate > 0 {
+		return l.MaxRate
+	}
+	return DefaultAdaptiveMaxRate
+}
+
+func (l *AdaptiveLimiter) increase() float64 {
+	if l.Increase > 0 {
+		return l.Increase
+	}
+	return DefaultAdaptiveIncrease
+}
+
+func (l *AdaptiveLimiter) decrease() float64 {
+	if l.Decrease > 0 && l.Decrease < 1 {
+		return l.Decrease
+	}
+	return DefaultAdaptiveDecrease
+}
+
+func (l *AdaptiveLimiter) cooldown() time.Duration {
+	if l.Cooldown == 0 {
+		return DefaultAdaptiveCooldown
+	}
+	return l.Cooldown
+}
+
+func (l *AdaptiveLimiter) clamp(rate float64) float64 {
+	return math.Max(l.minRate(), math.Min(l.maxRate(), rate))
+}
+
+// setRate 设置表的速率，令牌桶最多积累1秒的请求且至少为1个，否则速率小于1时无法发送请求
+func (t *adaptiveTable) setRate(rate float64, now time.Time) {
+	t.rate = rate
+	t.bucket.setRate(rate, math.Max(1, rate), now)
+}
+
+// table 返回表的状态，调用者需要持有l.mu
+func (l *AdaptiveLimiter) table(name string, now time.Time) *adaptiveTable {
+	if l.tables == nil {
+		l.tables = make(map[string]*adaptiveTable)
+	}
+	t, ok := l.tables[name]
+	if !ok {
+		t = &adaptiveTable{}
+		t.setRate(l.clamp(l.initialRate()), now)
+		l.tables[name] = t
+	}
+	return t
+}
+
+// Wait 等待直到tableNames中的每张表都可以发送一个请求
+func (l *AdaptiveLimiter) Wait(ctx context.Context, tableNames []string) error {
+	for {
+		now := time.Now()
+		var (
+			wait  time.Duration
+			table string
+		)
+		l.mu.Lock()
+		for _, name := range tableNames {
+			if d := l.table(name, now).bucket.reserve(1, now); d > wait {
+				wait, table = d, name
+			}
+		}
+		if wait == 0 {
+			for _, name := range tableNames {
+				l.tables[name].bucket.take(1)
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+			return &RateLimitError{TableName: table, Wait: wait, Adaptive: true}
+		}
+		if !sleep(ctx, wait) {
+			return &OTSClientError{Message: fmt.Sprintf("%s Wait for request rate canceled", ctx.Err().Error()), Err: ctx.Err()}
+		}
+	}
+}
+
+// Observe 根据请求结果调整各表的速率，throttled中的表降速，其余的表视为成功并加速。
+// 其他原因失败的请求不应调用Observe
+func (l *AdaptiveLimiter) Observe(tableNames []string, throttled map[string]bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, name := range tableNames {
+		t := l.table(name, now)
+		rate := t.rate
+		if throttled[name] {
+			t.throttles++
+			if now.Sub(t.lastDecrease) < l.cooldown() {
+				continue
+			}
+			rate *= l.decrease()
+			t.decreases++
+			t.lastDecrease = now
+		} else {
+			t.successes++
+			rate += l.increase() / rate
+		}
+		t.setRate(l.clamp(rate), now)
+	}
+}
+
+// Rate 返回表当前允许的每秒请求数，表未被访问过时返回0
+func (l *AdaptiveLimiter) Rate(tableName string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.tables[tableName]; ok {
+		return t.rate
+	}
+	return 0
+}
+
+// Rates 返回所有表的当前状态，按表名排序
+func (l *AdaptiveLimiter) Rates() []*TableRate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rates := make([]*TableRate, 0, len(l.tables))
+	for name, t := range l.tables {
+		rates = append(rates, &TableRate{
+			TableName:    name,
+			Rate:         t.rate,
+			Successes:    t.successes,
+			Throttles:    t.throttles,
+			Decreases:    t.decreases,
+			LastDecrease: t.lastDecrease,
+		})
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].TableName < rates[j].TableName
+	})
+	return rates
+}
+
+// dataTableNames 返回数据操作涉及的表名，表操作返回nil
+func dataTableNames(message proto.Message) []string {
+	switch message.(type) {
+	case *protobuf.ListTableRequest, *protobuf.CreateTableRequest, *protobuf.DeleteTableRequest,
+		*protobuf.DescribeTableRequest, *protobuf.UpdateTableRequest:
+		return nil
+	}
+	return requestTableNames(message)
+}
+
+// throttledTables 返回因能力单元不足或服务端繁忙而失败的表，包括批量操作中单行失败的表
+func throttledTables(tableNames []string, inv *Invocation, err error) map[string]bool {
+	throttled := make(map[string]bool)
+	if err != nil {
+		if IsThrottled(err) {
+			for _, name := range tableNames {
+				throttled[name] = true
+			}
+		}
+		return throttled
+	}
+	if inv == nil {
+		return throttled
+	}
+	switch m := inv.Response.(type) {
+	case *protobuf.BatchGetRowResponse:
+		for _, table := range m.GetTables() {
+			for _, row := range table.GetRows() {
+				if !row.GetIsOk() && throttlingErrorCodes[row.GetError().GetCode()] {
+					throttled[table.GetTableName()] = true
+				}
+			}
+		}
+	case *protobuf.BatchWriteRowResponse:
+		for _, table := range m.GetTables() {
+			for _, rows := range [][]*protobuf.RowInBatchWriteRowResponse{table.GetPutRows(), table.GetUpdateRows(), table.GetDeleteRows()} {
+				for _, row := range rows {
+					if !row.GetIsOk() && throttlingErrorCodes[row.GetError().GetCode()] {
+						throttled[table.GetTableName()] = true
+					}
+				}
+			}
+		}
+	}
+	return throttled
+}
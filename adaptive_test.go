@@ -0,0 +1,105 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestAdaptiveLimiterZeroValue(t *testing.T) {
+	var l AdaptiveLimiter
+	if err := l.Wait(context.Background(), []string{"users"}); err != nil {
+		t.Fatal(err)
+	}
+	if rate := l.Rate("users"); rate != DefaultAdaptiveInitialRate {
+		t.Fatalf("Rate() = %v, want %v", rate, DefaultAdaptiveInitialRate)
+	}
+	l.Observe([]string{"users"}, map[string]bool{"users": true})
+	if rate := l.Rate("users"); rate != DefaultAdaptiveInitialRate*DefaultAdaptiveDecrease {
+		t.Errorf("Rate() after throttle = %v, want %v", rate, DefaultAdaptiveInitialRate*DefaultAdaptiveDecrease)
+	}
+}
+
+func TestAdaptiveLimiterRateBelowOne(t *testing.T) {
+	l := &AdaptiveLimiter{InitialRate: 0.5, MinRate: 0.1}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx, []string{"users"}); err != nil {
+		t.Fatalf("Wait() at rate 0.5 = %v, want granted", err)
+	}
+	err := l.Wait(ctx, []string{"users"})
+	var limitErr *RateLimitError
+	if !errors.As(err, &limitErr) || !limitErr.Adaptive || limitErr.Wait < time.Second {
+		t.Fatalf("second Wait() = %v, want adaptive RateLimitError of about 2s", err)
+	}
+	if !strings.HasPrefix(err.Error(), "Request rate of table users") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func TestAdaptiveLimiterObserve(t *testing.T) {
+	l := &AdaptiveLimiter{InitialRate: 10, MinRate: 4, Increase: 5, Decrease: 0.5, Cooldown: time.Hour}
+	tables := []string{"a", "b"}
+
+	l.Observe(tables, map[string]bool{"a": true})
+	if a, b := l.Rate("a"), l.Rate("b"); a != 5 || b != 10.5 {
+		t.Fatalf("rates = %v, %v, want 5, 10.5", a, b)
+	}
+	// 冷却期内不再降速
+	l.Observe(tables, map[string]bool{"a": true})
+	if a := l.Rate("a"); a != 5 {
+		t.Fatalf("rate within cooldown = %v, want 5", a)
+	}
+
+	l.Cooldown = -1
+	l.Observe(tables, map[string]bool{"a": true})
+	if a := l.Rate("a"); a != 4 {
+		t.Errorf("rate = %v, want clamped to MinRate 4", a)
+	}
+	rates := l.Rates()
+	if len(rates) != 2 || rates[0].TableName != "a" || rates[0].Throttles != 3 || rates[0].Decreases != 2 || rates[1].Successes != 3 {
+		t.Errorf("Rates() = %+v", rates)
+	}
+}
+
+func TestThrottledTables(t *testing.T) {
+	tables := []string{"a", "b"}
+	if got := throttledTables(tables, nil, &OTSServiceError{Code: ErrorCodeServerBusy}); !got["a"] || !got["b"] {
+		t.Errorf("throttledTables(ServerBusy) = %v, want all tables", got)
+	}
+	if got := throttledTables(tables, nil, &OTSServiceError{Code: ErrorCodeConditionCheckFail}); len(got) != 0 {
+		t.Errorf("throttledTables(ConditionCheckFail) = %v, want none", got)
+	}
+
+	inv := &Invocation{Response: &protobuf.BatchWriteRowResponse{Tables: []*protobuf.TableInBatchWriteRowResponse{
+		{TableName: proto.String("a"), PutRows: []*protobuf.RowInBatchWriteRowResponse{{IsOk: proto.Bool(true)}}},
+		{TableName: proto.String("b"), DeleteRows: []*protobuf.RowInBatchWriteRowResponse{
+			{IsOk: proto.Bool(false), Error: &protobuf.Error{Code: proto.String(ErrorCodeNotEnoughCapacityUnit)}},
+		}},
+	}}}
+	if got := throttledTables(tables, inv, nil); got["a"] || !got["b"] {
+		t.Errorf("throttledTables(batch) = %v, want only b", got)
+	}
+}
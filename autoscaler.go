@@ -0,0 +1,408 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Autoscaler 的默认参数
+const (
+	DefaultAutoscaleInterval           = time.Minute
+	DefaultAutoscaleSustainedIntervals = 3
+	DefaultTargetUtilization           = 0.7
+	DefaultMaxUtilization              = 0.9
+	DefaultMinUtilization              = 0.3
+	// DefaultAdjustmentInterval 为两次调整预留读写吞吐量之间的最小间隔，与服务端的限制一致
+	DefaultAdjustmentInterval = 10 * time.Minute
+	// DefaultMaxDecreasesPerDay 为每张表每天最多下调预留读写吞吐量的次数，与服务端的限制一致
+	DefaultMaxDecreasesPerDay = 4
+)
+
+// Autoscaler 根据各表实际消耗的读写能力单元调整预留读写吞吐量。
+// 每个Interval统计一次消耗速率，利用率连续SustainedIntervals次高于MaxUtilization时上调，
+// 连续SustainedIntervals次低于MinUtilization时下调，调整后的利用率为TargetUtilization。
+// 调整遵守服务端返回的LastIncreaseTime、LastDescreaseTime和NumOfDescreasesToday的限制，
+// 每次决策都会通过Client.Logger记录原因。参数为0时使用对应的默认值。
+// 示例:
+//
+//	client.Autoscaler = gots.NewAutoscaler()
+//	client.Autoscaler.MaxCapacity = gots.CapacityUnit{Read: 1000, Write: 1000}
+//	client.StartAutoscaler(ctx)
+type Autoscaler struct {
+	// Tables 为需要调整的表，为空时调整所有通过该Client访问过的表
+	Tables []string
+	// Interval 为统计和决策的周期，为0时使用DefaultAutoscaleInterval
+	Interval time.Duration
+	// SustainedIntervals 为触发调整需要连续满足条件的周期数，为0时使用DefaultAutoscaleSustainedIntervals
+	SustainedIntervals int
+	// TargetUtilization 为调整后期望的利用率，即消耗速率/预留能力单元，为0时使用DefaultTargetUtilization
+	TargetUtilization float64
+	// MaxUtilization 和 MinUtilization 为触发上调和下调的利用率，
+	// 为0时使用DefaultMaxUtilization和DefaultMinUtilization
+	MaxUtilization float64
+	MinUtilization float64
+	// MinCapacity 和 MaxCapacity 为预留能力单元的上下限，MaxCapacity某个维度为0时该维度不设上限
+	MinCapacity CapacityUnit
+	MaxCapacity CapacityUnit
+	// AdjustmentInterval 为两次调整之间的最小间隔，为0时使用DefaultAdjustmentInterval，小于0时不限制
+	AdjustmentInterval time.Duration
+	// MaxDecreasesPerDay 为每天最多下调的次数，为0时使用DefaultMaxDecreasesPerDay，小于0时不限制
+	MaxDecreasesPerDay int32
+
+	mu       sync.Mutex
+	running  bool
+	consumed map[string]*CapacityUnit
+	known    map[string]bool
+	streaks  map[string]*scaleStreak
+	last     time.Time
+}
+
+// scaleStreak 为表在读写两个维度上连续高于或低于阈值的周期数，正数为高于，负数为低于
+type scaleStreak struct {
+	read  int
+	write int
+}
+
+// NewAutoscaler 返回使用默认参数的Autoscaler
+func NewAutoscaler() *Autoscaler {
+	return &Autoscaler{
+		Interval:           DefaultAutoscaleInterval,
+		SustainedIntervals: DefaultAutoscaleSustainedIntervals,
+		TargetUtilization:  DefaultTargetUtilization,
+		MaxUtilization:     DefaultMaxUtilization,
+		MinUtilization:     DefaultMinUtilization,
+		MinCapacity:        CapacityUnit{Read: 1, Write: 1},
+		AdjustmentInterval: DefaultAdjustmentInterval,
+		MaxDecreasesPerDay: DefaultMaxDecreasesPerDay,
+	}
+}
+
+func (a *Autoscaler) interval() time.Duration {
+	if a.Interval > 0 {
+		return a.Interval
+	}
+	return DefaultAutoscaleInterval
+}
+
+func (a *Autoscaler) sustainedIntervals() int {
+	if a.SustainedIntervals > 0 {
+		return a.SustainedIntervals
+	}
+	return DefaultAutoscaleSustainedIntervals
+}
+
+func (a *Autoscaler) targetUtilization() float64 {
+	if a.TargetUtilization > 0 {
+		return a.TargetUtilization
+	}
+	return DefaultTargetUtilization
+}
+
+func (a *Autoscaler) maxUtilization() float64 {
+	if a.MaxUtilization > 0 {
+		return a.MaxUtilization
+	}
+	return DefaultMaxUtilization
+}
+
+func (a *Autoscaler) minUtilization() float64 {
+	if a.MinUtilization > 0 {
+		return a.MinUtilization
+	}
+	return DefaultMinUtilization
+}
+
+func (a *Autoscaler) adjustmentInterval() time.Duration {
+	if a.AdjustmentInterval == 0 {
+		return DefaultAdjustmentInterval
+	}
+	return a.AdjustmentInterval
+}
+
+func (a *Autoscaler) maxDecreasesPerDay() int32 {
+	if a.MaxDecreasesPerDay == 0 {
+		return DefaultMaxDecreasesPerDay
+	}
+	return a.MaxDecreasesPerDay
+}
+
+// record 累计各表消耗的能力单元
+func (a *Autoscaler) record(consumed map[string]*CapacityUnit) {
+	if len(consumed) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.consumed == nil {
+		a.consumed = make(map[string]*CapacityUnit)
+		a.known = make(map[string]bool)
+	}
+	for name, cu := range consumed {
+		total, ok := a.consumed[name]
+		if !ok {
+			total = &CapacityUnit{}
+			a.consumed[name] = total
+		}
+		total.Read += cu.Read
+		total.Write += cu.Write
+		a.known[name] = true
+	}
+}
+
+// window 取出上一周期各表的消耗及周期时长
+func (a *Autoscaler) window(now time.Time) (map[string]*CapacityUnit, time.Duration, []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	consumed := a.consumed
+	a.consumed = make(map[string]*CapacityUnit)
+	elapsed := now.Sub(a.last)
+	if a.last.IsZero() {
+		elapsed = a.interval()
+	}
+	a.last = now
+
+	tables := a.Tables
+	if len(tables) == 0 {
+		for name := range a.known {
+			tables = append(tables, name)
+		}
+		sort.Strings(tables)
+	}
+	return consumed, elapsed, tables
+}
+
+// StartAutoscaler 在后台运行Client.Autoscaler，直到ctx结束。
+// Autoscaler为nil或已经在运行时不做任何事，ctx结束后可以再次启动
+func (c *Client) StartAutoscaler(ctx context.Context) {
+	a := c.Autoscaler
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if a.running {
+		a.mu.Unlock()
+		return
+	}
+	a.running = true
+	a.last = time.Now()
+	a.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(a.interval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.mu.Lock()
+				a.running = false
+				a.mu.Unlock()
+				return
+			case now := <-ticker.C:
+				c.autoscale(ctx, a, now)
+			}
+		}
+	}()
+}
+
+// autoscale 执行一个周期的决策
+func (c *Client) autoscale(ctx context.Context, a *Autoscaler, now time.Time) {
+	consumed, elapsed, tables := a.window(now)
+	if elapsed <= 0 {
+		return
+	}
+	for _, name := range tables {
+		if ctx.Err() != nil {
+			return
+		}
+		var cu CapacityUnit
+		if total, ok := consumed[name]; ok {
+			cu = *total
+		}
+		c.autoscaleTable(ctx, a, name, cu, elapsed, now)
+	}
+}
+
+// autoscaleTable 根据表在上一周期的消耗决定是否调整预留读写吞吐量
+func (c *Client) autoscaleTable(ctx context.Context, a *Autoscaler, name string, consumed CapacityUnit, elapsed time.Duration, now time.Time) {
+	_, details, err := c.DescribeTableWithContext(ctx, name)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		c.logScaling("warn", name, "describe table failed", "error", err.Error())
+		return
+	}
+	reserved := *details.CapacityUnit
+	readRate := float64(consumed.Read) / elapsed.Seconds()
+	writeRate := float64(consumed.Write) / elapsed.Seconds()
+
+	a.mu.Lock()
+	if a.streaks == nil {
+		a.streaks = make(map[string]*scaleStreak)
+	}
+	streak, ok := a.streaks[name]
+	if !ok {
+		streak = &scaleStreak{}
+		a.streaks[name] = streak
+	}
+	streak.read = a.nextStreak(streak.read, utilization(readRate, reserved.Read))
+	streak.write = a.nextStreak(streak.write, utilization(writeRate, reserved.Write))
+	readStreak, writeStreak := streak.read, streak.write
+	a.mu.Unlock()
+
+	target := reserved
+	target.Read = a.desired(reserved.Read, readRate, readStreak, a.MinCapacity.Read, a.MaxCapacity.Read)
+	target.Write = a.desired(reserved.Write, writeRate, writeStreak, a.MinCapacity.Write, a.MaxCapacity.Write)
+
+	args := []any{
+		"read_rate", readRate, "write_rate", writeRate,
+		"reserved_read", reserved.Read, "reserved_write", reserved.Write,
+	}
+	if target == reserved {
+		c.logScaling("debug", name, "keep reserved throughput: utilization within range or not sustained", args...)
+		return
+	}
+
+	increase := target.Read > reserved.Read || target.Write > reserved.Write
+	decrease := target.Read < reserved.Read || target.Write < reserved.Write
+	lastAdjust := time.Unix(details.LastIncreaseTime, 0)
+	if details.LastDescreaseTime > details.LastIncreaseTime {
+		lastAdjust = time.Unix(details.LastDescreaseTime, 0)
+	}
+	// LastIncreaseTime和LastDescreaseTime为服务端时间
+	interval := a.adjustmentInterval()
+	if since := c.protocol.serverTime(now).Sub(lastAdjust); since < interval {
+		c.logScaling("info", name, fmt.Sprintf("postpone adjustment: last adjustment was %v ago, minimum interval is %v", since.Round(time.Second), interval), args...)
+		return
+	}
+	if maxDecreases := a.maxDecreasesPerDay(); decrease && maxDecreases > 0 && details.NumOfDescreasesToday >= maxDecreases {
+		// 下调次数用完时只保留上调的维度
+		if target.Read < reserved.Read {
+			target.Read = reserved.Read
+		}
+		if target.Write < reserved.Write {
+			target.Write = reserved.Write
+		}
+		if !increase {
+			c.logScaling("info", name, fmt.Sprintf("skip decrease: %d decreases today, limit is %d", details.NumOfDescreasesToday, maxDecreases), args...)
+			return
+		}
+	}
+
+	reason := scalingReason(reserved, target, readRate, writeRate)
+	args = append(args, "target_read", target.Read, "target_write", target.Write)
+	if _, err := c.UpdateTableWithContext(ctx, name, &ReservedThroughput{CapacityUnit: &target}); err != nil {
+		c.logScaling("warn", name, "update reserved throughput failed: "+reason, append(args, "error", err.Error())...)
+		return
+	}
+	a.mu.Lock()
+	*streak = scaleStreak{}
+	a.mu.Unlock()
+	c.logScaling("info", name, "updated reserved throughput: "+reason, args...)
+}
+
+// nextStreak 根据本周期的利用率更新连续计数
+func (a *Autoscaler) nextStreak(streak int, util float64) int {
+	switch {
+	case util > a.maxUtilization():
+		if streak < 0 {
+			streak = 0
+		}
+		return streak + 1
+	case util < a.minUtilization():
+		if streak > 0 {
+			streak = 0
+		}
+		return streak - 1
+	}
+	return 0
+}
+
+// desired 返回一个维度上期望的预留能力单元，条件未持续满足时返回reserved
+func (a *Autoscaler) desired(reserved int32, rate float64, streak int, minCU, maxCU int32) int32 {
+	sustained := a.sustainedIntervals()
+	if streak < sustained && streak > -sustained {
+		return reserved
+	}
+	target := int32(math.MaxInt32)
+	if t := math.Ceil(rate / a.targetUtilization()); t < math.MaxInt32 {
+		target = int32(t)
+	}
+	if target < minCU {
+		target = minCU
+	}
+	if maxCU > 0 && target > maxCU {
+		target = maxCU
+	}
+	if (streak > 0 && target < reserved) || (streak < 0 && target > reserved) {
+		return reserved
+	}
+	return target
+}
+
+// utilization 返回消耗速率与预留能力单元的比值
+func utilization(rate float64, reserved int32) float64 {
+	if reserved <= 0 {
+		if rate > 0 {
+			return math.Inf(1)
+		}
+		return 0
+	}
+	return rate / float64(reserved)
+}
+
+func scalingReason(reserved, target CapacityUnit, readRate, writeRate float64) string {
+	reason := ""
+	describe := func(dim string, from, to int32, rate float64) {
+		if from == to {
+			return
+		}
+		if reason != "" {
+			reason += "; "
+		}
+		direction := "increase"
+		if to < from {
+			direction = "decrease"
+		}
+		reason += fmt.Sprintf("%s %s %d -> %d, consumed %.2f CU/s (utilization %.0f%%)", direction, dim, from, to, rate, utilization(rate, from)*100)
+	}
+	describe("read", reserved.Read, target.Read, readRate)
+	describe("write", reserved.Write, target.Write, writeRate)
+	return reason
+}
+
+// logScaling 通过Client.Logger记录自动调整的决策
+func (c *Client) logScaling(level, tableName, msg string, args ...any) {
+	if c.Logger == nil {
+		return
+	}
+	args = append([]any{"table", tableName}, args...)
+	msg = "ots autoscaler: " + msg
+	switch level {
+	case "debug":
+		c.Logger.Debug(msg, args...)
+	case "warn":
+		c.Logger.Warn(msg, args...)
+	default:
+		c.Logger.Info(msg, args...)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// fakeTable 通过拦截器模拟DescribeTable和UpdateTable，记录每次UpdateTable设置的预留能力单元
+type fakeTable struct {
+	details *protobuf.ReservedThroughputDetails
+	updates []CapacityUnit
+}
+
+func newFakeTable(read, write int32, lastIncrease time.Time, decreasesToday int32) *fakeTable {
+	return &fakeTable{details: &protobuf.ReservedThroughputDetails{
+		CapacityUnit:           &protobuf.CapacityUnit{Read: proto.Int32(read), Write: proto.Int32(write)},
+		LastIncreaseTime:       proto.Int64(lastIncrease.Unix()),
+		NumberOfDecreasesToday: proto.Int32(decreasesToday),
+	}}
+}
+
+func (f *fakeTable) intercept(ctx context.Context, inv *Invocation, next Handler) (err error) {
+	var resp proto.Message
+	switch req := inv.Request.(type) {
+	case *protobuf.DescribeTableRequest:
+		resp = &protobuf.DescribeTableResponse{
+			TableMeta:                 &protobuf.TableMeta{TableName: req.TableName},
+			ReservedThroughputDetails: f.details,
+		}
+	case *protobuf.UpdateTableRequest:
+		cu := req.GetReservedThroughput().GetCapacityUnit()
+		f.updates = append(f.updates, CapacityUnit{Read: cu.GetRead(), Write: cu.GetWrite()})
+		f.details.CapacityUnit = cu
+		resp = &protobuf.UpdateTableResponse{ReservedThroughputDetails: f.details}
+	default:
+		return fmt.Errorf("unexpected request %s", inv.APIName)
+	}
+	inv.ResponseBody, err = proto.Marshal(resp)
+	return err
+}
+
+// runAutoscaler 执行intervals个10秒的周期，每个周期消耗consumed
+func runAutoscaler(t *testing.T, c *Client, start time.Time, intervals int, consumed CapacityUnit) {
+	t.Helper()
+	a := c.Autoscaler
+	a.last = start
+	for i := 1; i <= intervals; i++ {
+		cu := consumed
+		a.record(map[string]*CapacityUnit{"users": &cu})
+		c.autoscale(context.Background(), a, start.Add(time.Duration(i)*10*time.Second))
+	}
+}
+
+func newAutoscaleClient(t *testing.T, a *Autoscaler, table *fakeTable) *Client {
+	t.Helper()
+	c := NewClient("http://127.0.0.1:1", "id", "key", "instance")
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c.Autoscaler = a
+	c.Interceptors = []Interceptor{table.intercept}
+	return c
+}
+
+func TestAutoscalerDecisions(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-time.Hour)
+	tests := []struct {
+		name      string
+		scaler    *Autoscaler
+		table     *fakeTable
+		intervals int
+		consumed  CapacityUnit
+		want      []CapacityUnit
+	}{
+		{
+			name:      "not sustained",
+			scaler:    NewAutoscaler(),
+			table:     newFakeTable(10, 10, longAgo, 0),
+			intervals: 2,
+			consumed:  CapacityUnit{Read: 50, Write: 100},
+		},
+		{
+			name:      "sustained increase",
+			scaler:    NewAutoscaler(),
+			table:     newFakeTable(10, 10, longAgo, 0),
+			intervals: 3,
+			consumed:  CapacityUnit{Read: 50, Write: 100},
+			want:      []CapacityUnit{{Read: 10, Write: 15}},
+		},
+		{
+			name:      "zero value uses defaults",
+			scaler:    &Autoscaler{},
+			table:     newFakeTable(10, 10, longAgo, 0),
+			intervals: 3,
+			consumed:  CapacityUnit{Read: 50, Write: 100},
+			want:      []CapacityUnit{{Read: 10, Write: 15}},
+		},
+		{
+			name:      "sustained decrease",
+			scaler:    NewAutoscaler(),
+			table:     newFakeTable(100, 10, longAgo, 0),
+			intervals: 3,
+			consumed:  CapacityUnit{Read: 70, Write: 50},
+			want:      []CapacityUnit{{Read: 10, Write: 10}},
+		},
+		{
+			name:      "decreases exhausted",
+			scaler:    NewAutoscaler(),
+			table:     newFakeTable(100, 10, longAgo, DefaultMaxDecreasesPerDay),
+			intervals: 3,
+			consumed:  CapacityUnit{Read: 70, Write: 50},
+		},
+		{
+			name:      "adjusted recently",
+			scaler:    NewAutoscaler(),
+			table:     newFakeTable(10, 10, now.Add(-5*time.Minute), 0),
+			intervals: 3,
+			consumed:  CapacityUnit{Read: 50, Write: 100},
+		},
+		{
+			name:      "capped by MaxCapacity",
+			scaler:    &Autoscaler{MaxCapacity: CapacityUnit{Write: 12}},
+			table:     newFakeTable(10, 10, longAgo, 0),
+			intervals: 3,
+			consumed:  CapacityUnit{Read: 50, Write: 100},
+			want:      []CapacityUnit{{Read: 10, Write: 12}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAutoscaleClient(t, tt.scaler, tt.table)
+			runAutoscaler(t, c, now, tt.intervals, tt.consumed)
+			if fmt.Sprint(tt.table.updates) != fmt.Sprint(tt.want) {
+				t.Errorf("updates = %v, want %v", tt.table.updates, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoscalerUsesServerClock(t *testing.T) {
+	now := time.Now()
+	// 本地时钟比服务端慢1小时，服务端20分钟前调整过
+	table := newFakeTable(10, 10, now.Add(time.Hour-20*time.Minute), 0)
+	c := newAutoscaleClient(t, NewAutoscaler(), table)
+	c.protocol.SetClockOffset(time.Hour)
+
+	runAutoscaler(t, c, now, 3, CapacityUnit{Read: 50, Write: 100})
+	if len(table.updates) != 1 {
+		t.Errorf("updates = %v, want one increase", table.updates)
+	}
+}
+
+func TestStartAutoscalerOnce(t *testing.T) {
+	c := NewClient("http://127.0.0.1:1", "id", "key", "instance")
+	c.Autoscaler = &Autoscaler{}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.StartAutoscaler(ctx)
+	c.StartAutoscaler(ctx)
+	c.Autoscaler.mu.Lock()
+	running := c.Autoscaler.running
+	c.Autoscaler.mu.Unlock()
+	if !running {
+		t.Fatal("autoscaler is not running")
+	}
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.Autoscaler.mu.Lock()
+		running := c.Autoscaler.running
+		c.Autoscaler.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("autoscaler still running after ctx canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxBatchGetRowCount 为OTS允许的单次BatchGetRow请求的最大行数
+	MaxBatchGetRowCount = 100
+	// DefaultBatchGetRowParallelism 默认BatchGetRow拆分后的并发请求数
+	DefaultBatchGetRowParallelism = 4
+)
+
+// batchGetRowChunk 是拆分后的一次BatchGetRow请求，offsets记录每张表的第一行在原请求中的下标
+type batchGetRowChunk struct {
+	items   map[string]BatchGetRowItem
+	offsets map[string]int
+}
+
+func sortedTableNames(items map[string]BatchGetRowItem) []string {
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitBatchGetRowItems 将请求按表名顺序拆分为每个不超过limit行的请求
+func splitBatchGetRowItems(items map[string]BatchGetRowItem, limit int) []*batchGetRowChunk {
+	if limit <= 0 || limit > MaxBatchGetRowCount {
+		limit = MaxBatchGetRowCount
+	}
+	var chunks []*batchGetRowChunk
+	var chunk *batchGetRowChunk
+	size := 0
+	for _, name := range sortedTableNames(items) {
+		item := items[name]
+		for offset := 0; offset < len(item.PrimaryKeys); {
+			if chunk == nil || size == limit {
+				chunk = &batchGetRowChunk{
+					items:   make(map[string]BatchGetRowItem),
+					offsets: make(map[string]int),
+				}
+				chunks = append(chunks, chunk)
+				size = 0
+			}
+			end := offset + limit - size
+			if end > len(item.PrimaryKeys) {
+				end = len(item.PrimaryKeys)
+			}
+			chunk.items[name] = BatchGetRowItem{
+				PrimaryKeys: item.PrimaryKeys[offset:end],
+				ColumnNames: item.ColumnNames,
+			}
+			chunk.offsets[name] = offset
+			size += end - offset
+			offset = end
+		}
+	}
+	return chunks
+}
+
+func (c *Client) batchGetRowWithRetry(ctx context.Context, items map[string]BatchGetRowItem) (*BatchGetRowResponse, error) {
+	resp, err := c.batchGetRow(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	if c.RetryBatchGetRowFailures {
+		resp = c.retryBatchGetRowFailures(ctx, items, resp)
+	}
+	return resp, nil
+}
+
+// dispatchBatchGetRow 并发发送拆分后的请求，并按表和原请求中的顺序合并结果。任意一个请求失败时返回该错误
+func (c *Client) dispatchBatchGetRow(ctx context.Context, items map[string]BatchGetRowItem, chunks []*batchGetRowChunk) (*BatchGetRowResponse, error) {
+	parallelism := c.BatchGetRowParallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	responses := make([]*BatchGetRowResponse, len(chunks))
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, parallelism)
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk *batchGetRowChunk) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			resp, err := c.batchGetRowWithRetry(ctx, chunk.items)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			responses[i] = resp
+		}(i, chunk)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("%s BatchGetRow canceled", err.Error()), Err: err}
+	}
+
+	merged := &BatchGetRowResponse{}
+	tables := make(map[string]*TableInBatchGetRowResponse, len(items))
+	for _, name := range sortedTableNames(items) {
+		t := &TableInBatchGetRowResponse{
+			TableName: name,
+			Rows:      make([]*RowInBatchGetRowResponse, len(items[name].PrimaryKeys)),
+		}
+		tables[name] = t
+		merged.Tables = append(merged.Tables, t)
+	}
+	for i, chunk := range chunks {
+		for name, item := range chunk.items {
+			t := responses[i].Table(name)
+			if t == nil || len(t.Rows) != len(item.PrimaryKeys) {
+				return nil, &OTSClientError{Message: fmt.Sprintf("Rows count mismatch for table %s in BatchGetRow response", name)}
+			}
+			copy(tables[name].Rows[chunk.offsets[name]:], t.Rows)
+		}
+	}
+	return merged, nil
+}
+
+func (c *Client) batchGetRow(ctx context.Context, items map[string]BatchGetRowItem) (*BatchGetRowResponse, error) {
+	message, err := c.encoder.EncodeBatchGetRow(items)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.vist(ctx, "BatchGetRow", message)
+	if err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeBatchGetRow(data)
+}
+
+// failedBatchGetRowItems 收集因可重试错误而失败的行，positions记录这些行在resp中对应表的下标
+func failedBatchGetRowItems(items map[string]BatchGetRowItem, resp *BatchGetRowResponse) (failed map[string]BatchGetRowItem, positions map[string][]int, cause error) {
+	failed = make(map[string]BatchGetRowItem)
+	positions = make(map[string][]int)
+	for _, t := range resp.Tables {
+		item, ok := items[t.TableName]
+		if !ok || len(item.PrimaryKeys) != len(t.Rows) {
+			continue
+		}
+		for i, row := range t.Rows {
+			if row.IsOk || row.Error == nil {
+				continue
+			}
+			if !shouldRetry("BatchGetRow", row.Error) {
+				continue
+			}
+			if cause == nil {
+				cause = row.Error
+			}
+			f := failed[t.TableName]
+			f.PrimaryKeys = append(f.PrimaryKeys, item.PrimaryKeys[i])
+			f.ColumnNames = item.ColumnNames
+			failed[t.TableName] = f
+			positions[t.TableName] = append(positions[t.TableName], i)
+		}
+	}
+	return failed, positions, cause
+}
+
+// retryBatchGetRowFailures 按RetryPolicy重新读取失败的行，并将结果按原请求顺序合并到resp中。
+// 重试请求本身失败时，resp中对应的行保留原来的错误
+func (c *Client) retryBatchGetRowFailures(ctx context.Context, items map[string]BatchGetRowItem, resp *BatchGetRowResponse) *BatchGetRowResponse {
+	if c.RetryPolicy == nil {
+		return resp
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		failed, positions, cause := failedBatchGetRowItems(items, resp)
+		if len(failed) == 0 {
+			return resp
+		}
+		delay, retry := c.RetryPolicy.ShouldRetry("BatchGetRow", attempt, time.Since(start), cause)
+		if !retry || !sleep(ctx, delay) {
+			return resp
+		}
+		retried, err := c.batchGetRow(ctx, failed)
+		if err != nil {
+			return resp
+		}
+		for _, t := range retried.Tables {
+			pos := positions[t.TableName]
+			orig := resp.Table(t.TableName)
+			if orig == nil || len(pos) != len(t.Rows) {
+				continue
+			}
+			for j, row := range t.Rows {
+				orig.Rows[pos[j]] = row
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// fakeBatchGetRowHandler 返回模拟BatchGetRow的fakeHandler，exists判断行是否存在，
+// fail返回行失败时的错误码，参数为表名、行的uid和第几次请求。requests记录每次请求中各行的uid
+func fakeBatchGetRowHandler(exists func(table string, uid int64) bool, fail func(table string, uid int64, attempt int) string, requests *[][]int64) fakeHandler {
+	var mu sync.Mutex
+	attempts := 0
+	return func(apiName string, body []byte) (int, proto.Message) {
+		req := &protobuf.BatchGetRowRequest{}
+		if apiName != "BatchGetRow" || proto.Unmarshal(body, req) != nil {
+			return fakeError(400, "OTSParameterInvalid")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+
+		resp := &protobuf.BatchGetRowResponse{}
+		var uids []int64
+		for _, table := range req.GetTables() {
+			rows := &protobuf.TableInBatchGetRowResponse{TableName: table.TableName}
+			for _, row := range table.GetRows() {
+				uid := row.GetPrimaryKey()[0].GetValue().GetVInt()
+				uids = append(uids, uid)
+				result := &protobuf.RowInBatchGetRowResponse{IsOk: proto.Bool(true), Consumed: consumed(1, 0), Row: &protobuf.Row{}}
+				if code := fail(table.GetTableName(), uid, attempts); code != "" {
+					result = &protobuf.RowInBatchGetRowResponse{IsOk: proto.Bool(false), Error: &protobuf.Error{Code: proto.String(code)}}
+				} else if exists(table.GetTableName(), uid) {
+					result.Row.PrimaryKeyColumns = row.GetPrimaryKey()
+				}
+				rows.Rows = append(rows.Rows, result)
+			}
+			resp.Tables = append(resp.Tables, rows)
+		}
+		if requests != nil {
+			*requests = append(*requests, uids)
+		}
+		return 200, resp
+	}
+}
+
+func batchGetItems(table string, uids ...int64) map[string]BatchGetRowItem {
+	pks := make([]PrimaryKey, len(uids))
+	for i, uid := range uids {
+		pks[i] = NewPrimaryKey().Add("uid", uid)
+	}
+	return map[string]BatchGetRowItem{table: {PrimaryKeys: pks}}
+}
+
+// batchRowUIDs 返回表中每一行的uid，行不存在时为0，失败时为错误码
+func batchRowUIDs(t *TableInBatchGetRowResponse) string {
+	results := make([]string, len(t.Rows))
+	for i, row := range t.Rows {
+		switch {
+		case !row.IsOk:
+			results[i] = row.Error.Code
+		case len(row.Row.PrimaryKeyColumns) == 0:
+			results[i] = "0"
+		default:
+			results[i] = fmt.Sprint(row.Row.PrimaryKey().Get("uid").VInt)
+		}
+	}
+	return fmt.Sprint(results)
+}
+
+func TestBatchGetRowRetryFailedRows(t *testing.T) {
+	var requests [][]int64
+	exists := func(table string, uid int64) bool { return true }
+	// 第一次请求中uid为奇数的行因能力单元不足失败，uid为4的行始终因参数错误失败
+	client := newFakeClient(t, fakeBatchGetRowHandler(exists, func(table string, uid int64, attempt int) string {
+		switch {
+		case uid == 4:
+			return "OTSParameterInvalid"
+		case attempt == 1 && uid%2 == 1:
+			return "OTSNotEnoughCapacityUnit"
+		}
+		return ""
+	}, &requests))
+	policy := NewDefaultRetryPolicy()
+	policy.InitialInterval = -1
+	client.RetryPolicy = policy
+	client.RetryBatchGetRowFailures = true
+
+	resp, err := client.BatchGetRow(batchGetItems("users", 1, 2, 3, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := batchRowUIDs(resp.Table("users")); got != "[1 2 3 OTSParameterInvalid]" {
+		t.Errorf("rows = %s, want retried rows merged in request order", got)
+	}
+	if fmt.Sprint(requests) != "[[1 2 3 4] [1 3]]" {
+		t.Errorf("requests = %v, want only throttled rows retried", requests)
+	}
+
+	requests = nil
+	client = newFakeClient(t, fakeBatchGetRowHandler(exists, func(table string, uid int64, attempt int) string {
+		if uid == 1 {
+			return "OTSNotEnoughCapacityUnit"
+		}
+		return ""
+	}, &requests))
+	client.RetryPolicy = policy
+	resp, err = client.BatchGetRow(batchGetItems("users", 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := batchRowUIDs(resp.Table("users")); got != "[OTSNotEnoughCapacityUnit 2]" || len(requests) != 1 {
+		t.Errorf("rows = %s after %d requests, want failures kept without retry", got, len(requests))
+	}
+}
+
+func TestBatchGetRowChunks(t *testing.T) {
+	var requests [][]int64
+	exists := func(table string, uid int64) bool {
+		if table == "groups" {
+			return uid == 2
+		}
+		return uid <= 5
+	}
+	noFailure := func(table string, uid int64, attempt int) string { return "" }
+	client := newFakeClient(t, fakeBatchGetRowHandler(exists, noFailure, &requests))
+	client.BatchGetRowLimit = 3
+	client.BatchGetRowParallelism = 2
+
+	items := batchGetItems("users", 5, 1, 9, 3, 2)
+	items["groups"] = batchGetItems("groups", 2, 1, 2, 7)["groups"]
+	resp, err := client.BatchGetRow(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Tables) != 2 || resp.Tables[0].TableName != "groups" || resp.Tables[1].TableName != "users" {
+		t.Fatalf("tables = %v, want groups and users sorted by name", resp.Tables)
+	}
+	if got := batchRowUIDs(resp.Table("users")); got != "[5 1 0 3 2]" {
+		t.Errorf("users rows = %s, want request order", got)
+	}
+	if got := batchRowUIDs(resp.Table("groups")); got != "[2 0 2 0]" {
+		t.Errorf("groups rows = %s, want request order", got)
+	}
+	if len(requests) != 3 {
+		t.Errorf("chunks = %v, want 3 requests", requests)
+	}
+	for _, uids := range requests {
+		if len(uids) > 3 {
+			t.Errorf("chunks = %v, want at most 3 rows each", requests)
+		}
+	}
+
+	handler := fakeBatchGetRowHandler(exists, noFailure, nil)
+	client = newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		req := &protobuf.BatchGetRowRequest{}
+		if proto.Unmarshal(body, req) == nil && len(req.GetTables()) == 2 {
+			return fakeError(400, "OTSParameterInvalid")
+		}
+		return handler(apiName, body)
+	})
+	client.BatchGetRowLimit = 3
+	_, err = client.BatchGetRow(items)
+	if e, ok := err.(*OTSServiceError); !ok || e.Code != "OTSParameterInvalid" {
+		t.Errorf("BatchGetRow() with a failing chunk = %v, want OTSParameterInvalid", err)
+	}
+}
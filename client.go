@@ -17,11 +17,13 @@
 package gots
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -33,6 +35,8 @@ const (
 	DefaultSocketTimeout = 50
 	// DefaultMaxConnection 默认链接池最大链接数
 	DefaultMaxConnection = 50
+	// DefaultIdleConnTimeout 默认空闲链接保持时间
+	DefaultIdleConnTimeout = 90 * time.Second
 )
 
 // Client 实现了OTS服务的所有接口。用户可以通过NewClient方法创建Client实例
@@ -44,11 +48,51 @@ type Client struct {
 	Encoding      string
 	SocketTimeout float32
 	MaxConnection int
-	Debug         bool
-	Logger        *log.Logger
-	protocol      *Protocol
-	encoder       *Encoder
-	decoder       *Decoder
+	// Logger 不为nil时记录每一次请求尝试，可以直接使用*slog.Logger
+	Logger Logger
+	// LogOptions 控制是否记录请求内容以及如何截断和隐藏
+	LogOptions LogOptions
+	// Credentials 不为nil时，每次请求签名前从中获取凭证，AccessID和AccessKey将被忽略
+	Credentials CredentialsProvider
+	// MaxDateSkew 为响应中x-ots-date与本地时间允许的最大差值，小于0时不检查
+	MaxDateSkew time.Duration
+	// ClockSkewCompensation 为true时根据响应时间校正签名使用的本地时间
+	ClockSkewCompensation bool
+	// CapacityLimiter 不为nil时按表的预留能力单元在客户端限流
+	CapacityLimiter *CapacityLimiter
+	// AdaptiveLimiter 不为nil时按表限制每秒请求数，并根据能力单元不足等错误自动调整
+	AdaptiveLimiter *AdaptiveLimiter
+	// Autoscaler 不为nil时记录各表消耗的能力单元，调用StartAutoscaler后在后台调整预留读写吞吐量
+	Autoscaler *Autoscaler
+	// Interceptors 按顺序包装每一次请求的发送，第一个在最外层
+	Interceptors []Interceptor
+	// Tracer 不为nil时，每次API调用（包含重试）都会创建一个span
+	Tracer Tracer
+	// TraceHeader 为传递调用方trace ID的请求头，trace ID通过ContextWithTraceID设置，为空时不传递。
+	// 不能以x-ots-开头，否则会导致签名失效
+	TraceHeader string
+	// RetryPolicy 决定请求失败后是否重试，为nil时不重试。默认不重试，需要时设置为NewDefaultRetryPolicy()，
+	// 它只对幂等的API，以及流控、建立链接失败等请求未被执行的错误重试
+	RetryPolicy RetryPolicy
+	// RetryBatchGetRowFailures 为true时，BatchGetRow会按RetryPolicy重新读取因可重试错误而失败的行，
+	// 并将结果合并到原响应中
+	RetryBatchGetRowFailures bool
+	// BatchGetRowLimit 为每次BatchGetRow请求的最大行数，超出时请求会被拆分，默认为MaxBatchGetRowCount
+	BatchGetRowLimit int
+	// BatchGetRowParallelism 为拆分后同时发送的最大请求数，默认为DefaultBatchGetRowParallelism
+	BatchGetRowParallelism int
+	// CheckPrimaryKey 为true时，发送请求前会根据DescribeTable获取的表结构重排并校验主键，表结构会被缓存
+	CheckPrimaryKey bool
+	// HTTPClient 不为nil时，使用该HTTP客户端发送请求，SocketTimeout、MaxConnection以及Transport将被忽略
+	HTTPClient *http.Client
+	// Transport 不为nil时，使用该RoundTripper代替默认的链接池，SocketTimeout和MaxConnection将被忽略
+	Transport  http.RoundTripper
+	protocol   *Protocol
+	httpClient *http.Client
+	encoder    *Encoder
+	decoder    *Decoder
+	tableMetas map[string]*TableMeta
+	metaLock   sync.RWMutex
 }
 
 // NewClient 方法返回一个Client实例
@@ -66,7 +110,13 @@ func NewClient(endPoint, accessID, accessKey, instanceName string) *Client {
 		Encoding:      DefaultEncoding,
 		SocketTimeout: DefaultSocketTimeout,
 		MaxConnection: DefaultMaxConnection,
-		Debug:         false,
+		TraceHeader:   DefaultTraceHeader,
+
+		MaxDateSkew:           DefaultMaxDateSkew,
+		ClockSkewCompensation: true,
+
+		BatchGetRowLimit:       MaxBatchGetRowCount,
+		BatchGetRowParallelism: DefaultBatchGetRowParallelism,
 	}
 }
 
@@ -77,42 +127,177 @@ func (c *Client) Init() error {
 		AccessID:     c.AccessID,
 		AccessKey:    c.AccessKey,
 		InstanceName: c.InstanceName,
+		Credentials:  c.Credentials,
+
+		MaxDateSkew:           c.MaxDateSkew,
+		ClockSkewCompensation: c.ClockSkewCompensation,
 	}
 	c.encoder = &Encoder{encoding: c.Encoding}
 	c.decoder = &Decoder{encoding: c.Encoding}
+	c.tableMetas = make(map[string]*TableMeta)
+	c.httpClient = c.HTTPClient
+	if c.httpClient == nil {
+		transport := c.Transport
+		if transport == nil {
+			transport = c.newTransport()
+		}
+		c.httpClient = &http.Client{Transport: transport}
+	}
 	return nil
 }
 
-func (c *Client) vist(apiName string, message proto.Message) (data []byte, err error) {
+// ClockOffset 返回记录的本地时钟偏差，即服务端时间减去本地时间
+func (c *Client) ClockOffset() time.Duration {
+	return c.protocol.ClockOffset()
+}
+
+// newTransport 创建使用链接池的Transport，每个host最多MaxConnection个链接，空闲链接会被复用。
+// 建立链接、TLS握手以及等待响应头的超时时间均为SocketTimeout秒
+func (c *Client) newTransport() *http.Transport {
+	timeout := time.Duration(c.SocketTimeout * float32(time.Second))
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          c.MaxConnection,
+		MaxIdleConnsPerHost:   c.MaxConnection,
+		MaxConnsPerHost:       c.MaxConnection,
+		IdleConnTimeout:       DefaultIdleConnTimeout,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+}
+
+func (c *Client) vist(ctx context.Context, apiName string, message proto.Message) (data []byte, err error) {
 	body, err := proto.Marshal(message)
 	if err != nil {
 		return nil, &OTSClientError{Message: fmt.Sprintf("%s Marshal protocol buffer failed", err.Error())}
 	}
-	if c.Debug && c.Logger != nil {
-		c.Logger.Printf(`Request: %s data: %s`, apiName, message.String())
+
+	var (
+		span Span
+		info *SpanInfo
+	)
+	if c.Tracer != nil {
+		info = &SpanInfo{
+			APIName:    apiName,
+			TableNames: requestTableNames(message),
+			TraceID:    TraceIDFromContext(ctx),
+			Start:      time.Now(),
+		}
+		ctx, span = c.Tracer.StartSpan(ctx, info)
+		if traceID := TraceIDFromContext(ctx); traceID != "" {
+			info.TraceID = traceID
+		}
+	}
+
+	start := time.Now()
+	var inv *Invocation
+	for attempt := 1; ; attempt++ {
+		var done func(*Invocation, error)
+		if done, err = c.limit(ctx, message); err != nil {
+			inv = nil
+			break
+		}
+		inv, err = c.send(ctx, apiName, message, body, attempt)
+		done(inv, err)
+		c.logPayload(apiName, message, inv)
+		if c.Autoscaler != nil && err == nil {
+			c.Autoscaler.record(inv.ConsumedCapacity())
+		}
+		if err == nil || c.RetryPolicy == nil {
+			break
+		}
+		delay, retry := c.RetryPolicy.ShouldRetry(apiName, attempt, time.Since(start), err)
+		if !retry {
+			break
+		}
+		c.logAttempt(apiName, message, inv, err, true)
+		if !sleep(ctx, delay) {
+			err = &OTSClientError{Message: fmt.Sprintf("%s Wait for retry canceled, last error: %s", ctx.Err().Error(), err.Error()), Err: ctx.Err()}
+			break
+		}
+	}
+	// 只记录一次最终结果，重试等待被ctx打断时为ctx的错误
+	c.logAttempt(apiName, message, inv, err, false)
+	if span != nil {
+		info.end(inv, err)
+		span.End(info, err)
 	}
-	req, err := c.protocol.MakeRequest(apiName, body)
+	if inv == nil {
+		return nil, err
+	}
+	return inv.ResponseBody, err
+}
+
+// send 发送一次请求，每次调用都会使用当前时间重新签名，并依次经过Interceptors。
+// 请求未能构建时返回的Invocation为nil
+func (c *Client) send(ctx context.Context, apiName string, message proto.Message, body []byte, attempt int) (inv *Invocation, err error) {
+	req, creds, err := c.protocol.makeRequest(ctx, apiName, body)
 	if err != nil {
 		return nil, err
 	}
-	// TODO: Use connection pool
-	response, err := http.DefaultClient.Do(req)
+	if traceID := TraceIDFromContext(ctx); traceID != "" && c.TraceHeader != "" {
+		req.Header.Set(c.TraceHeader, traceID)
+	}
+	inv = &Invocation{
+		APIName:     apiName,
+		Request:     message,
+		HTTPRequest: req,
+		Attempt:     attempt,
+		Start:       time.Now(),
+	}
+	handler := func(ctx context.Context, inv *Invocation) error {
+		return c.roundTrip(ctx, inv, creds)
+	}
+	if len(c.Interceptors) > 0 {
+		err = chainHandler(c.Interceptors, handler)(ctx, inv)
+	} else {
+		err = handler(ctx, inv)
+	}
+	return inv, err
+}
+
+// roundTrip 发送inv中的HTTP请求，校验响应并填充inv
+func (c *Client) roundTrip(ctx context.Context, inv *Invocation, creds *Credentials) (err error) {
+	defer func() {
+		inv.Duration = time.Since(inv.Start)
+	}()
+	req := inv.HTTPRequest
+	if req.Context() != ctx {
+		req = req.WithContext(ctx)
+	}
+	response, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, &OTSClientError{Message: fmt.Sprintf("%s Send request failed", err.Error())}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return &OTSClientError{Message: fmt.Sprintf("%s Send request failed", err.Error()), Err: ctxErr}
+		}
+		return &OTSClientError{Message: fmt.Sprintf("%s Send request failed", err.Error()), Err: err}
 	}
 	defer response.Body.Close()
-	data, err = ioutil.ReadAll(response.Body)
+	inv.StatusCode = response.StatusCode
+	inv.RequestID = response.Header.Get(HeaderOTSRequestID)
+	data, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, &OTSClientError{Message: "Read data faild in response"}
+		return &OTSClientError{Message: "Read data faild in response", Err: err}
 	}
+	inv.ResponseBody = data
 
-	headers := response.Header
-	newHeaders := make(map[string]string, len(headers))
-	for k := range headers {
-		lk := strings.ToLower(k)
-		newHeaders[lk] = headers.Get(k)
+	if err := c.protocol.parseResponse(creds, inv.APIName, response.StatusCode, lowerHeaders(response.Header), data); err != nil {
+		return err
+	}
+	if c.decodeResponses() {
+		inv.Response, err = decodeResponse(inv.APIName, data)
 	}
-	return data, c.protocol.ParseResponse(apiName, response.StatusCode, newHeaders, data)
+	return err
+}
+
+// decodeResponses 返回是否需要为Invocation解码响应
+func (c *Client) decodeResponses() bool {
+	return len(c.Interceptors) > 0 || c.Tracer != nil || c.CapacityLimiter != nil || c.AdaptiveLimiter != nil ||
+		c.Autoscaler != nil || (c.Logger != nil && c.LogOptions.Payload)
 }
 
 // ListTable 方法用于获取所有表名。
@@ -120,11 +305,16 @@ func (c *Client) vist(apiName string, message proto.Message) (data []byte, err e
 //
 // names, err := client.ListTable()
 func (c *Client) ListTable() (names []string, err error) {
+	return c.ListTableWithContext(context.Background())
+}
+
+// ListTableWithContext 同ListTable，ctx用于取消请求或设置超时
+func (c *Client) ListTableWithContext(ctx context.Context) (names []string, err error) {
 	message, err := c.encoder.EncodeListTable()
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("ListTable", message)
+	data, err := c.vist(ctx, "ListTable", message)
 	if err != nil {
 		return nil, err
 	}
@@ -156,14 +346,23 @@ func (c *Client) ListTable() (names []string, err error) {
 //
 // resp, err := client.CreateTable("sample_table", primaryKey, rt)
 func (c *Client) CreateTable(name string, primaryKey []*ColumnSchema, rt *ReservedThroughput) (*CreateTableResponse, error) {
+	return c.CreateTableWithContext(context.Background(), name, primaryKey, rt)
+}
+
+// CreateTableWithContext 同CreateTable，ctx用于取消请求或设置超时
+func (c *Client) CreateTableWithContext(ctx context.Context, name string, primaryKey []*ColumnSchema, rt *ReservedThroughput) (*CreateTableResponse, error) {
 	message, err := c.encoder.EncodeCreateTable(name, primaryKey, rt)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("CreateTable", message)
+	data, err := c.vist(ctx, "CreateTable", message)
 	if err != nil {
 		return nil, err
 	}
+	c.InvalidateTableMeta(name)
+	if c.CapacityLimiter != nil {
+		c.CapacityLimiter.Remove(name)
+	}
 	return c.decoder.DecodeCreateTable(data)
 }
 
@@ -173,14 +372,23 @@ func (c *Client) CreateTable(name string, primaryKey []*ColumnSchema, rt *Reserv
 //
 // resp, err := client.DeleteTable("sample_table")
 func (c *Client) DeleteTable(name string) (*DeleteTableResponse, error) {
+	return c.DeleteTableWithContext(context.Background(), name)
+}
+
+// DeleteTableWithContext 同DeleteTable，ctx用于取消请求或设置超时
+func (c *Client) DeleteTableWithContext(ctx context.Context, name string) (*DeleteTableResponse, error) {
 	message, err := c.encoder.EncodeDeleteTable(name)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("DeleteTable", message)
+	data, err := c.vist(ctx, "DeleteTable", message)
 	if err != nil {
 		return nil, err
 	}
+	c.InvalidateTableMeta(name)
+	if c.CapacityLimiter != nil {
+		c.CapacityLimiter.Remove(name)
+	}
 	return c.decoder.DecodeDeleteTable(data)
 }
 
@@ -190,15 +398,28 @@ func (c *Client) DeleteTable(name string) (*DeleteTableResponse, error) {
 //
 // resp, err := client.DescribeTable("sample_table")
 func (c *Client) DescribeTable(name string) (*TableMeta, *ReservedThoughputDetails, error) {
+	return c.DescribeTableWithContext(context.Background(), name)
+}
+
+// DescribeTableWithContext 同DescribeTable，ctx用于取消请求或设置超时
+func (c *Client) DescribeTableWithContext(ctx context.Context, name string) (*TableMeta, *ReservedThoughputDetails, error) {
 	message, err := c.encoder.EncodeDescribeTable(name)
 	if err != nil {
 		return nil, nil, err
 	}
-	data, err := c.vist("DescribeTable", message)
+	data, err := c.vist(ctx, "DescribeTable", message)
 	if err != nil {
 		return nil, nil, err
 	}
-	return c.decoder.DecodeDescribeTable(data)
+	tm, rtd, err := c.decoder.DecodeDescribeTable(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.cacheTableMeta(tm)
+	if c.CapacityLimiter != nil {
+		c.CapacityLimiter.SetCapacity(name, rtd.CapacityUnit)
+	}
+	return tm, rtd, nil
 }
 
 // UpdateTable 跟新表属性，目前只支持修改预留读写吞吐量
@@ -214,87 +435,221 @@ func (c *Client) DescribeTable(name string) (*TableMeta, *ReservedThoughputDetai
 // }
 // resp, err := client.UpdateTable("sample_table", rt)
 func (c *Client) UpdateTable(name string, reservedThroughput *ReservedThroughput) (*UpdateTableResponse, error) {
+	return c.UpdateTableWithContext(context.Background(), name, reservedThroughput)
+}
+
+// UpdateTableWithContext 同UpdateTable，ctx用于取消请求或设置超时
+func (c *Client) UpdateTableWithContext(ctx context.Context, name string, reservedThroughput *ReservedThroughput) (*UpdateTableResponse, error) {
 	message, err := c.encoder.EncodeUpdateTable(name, reservedThroughput)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("UpdateTable", message)
+	data, err := c.vist(ctx, "UpdateTable", message)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.decoder.DecodeUpdateTable(data)
 	if err != nil {
 		return nil, err
 	}
-	return c.decoder.DecodeUpdateTable(data)
+	if c.CapacityLimiter != nil {
+		c.CapacityLimiter.SetCapacity(name, resp.ReservedThoughputDetails.CapacityUnit)
+	}
+	return resp, nil
+}
+
+func (c *Client) GetRow(name string, primaryKey PrimaryKey, columnNames []string) (*GetRowResponse, error) {
+	return c.GetRowWithContext(context.Background(), name, primaryKey, columnNames)
 }
 
-func (c *Client) GetRow(name string, primaryKey map[string]interface{}, columnNames []string) (*GetRowResponse, error) {
+// GetRowWithContext 同GetRow，ctx用于取消请求或设置超时
+func (c *Client) GetRowWithContext(ctx context.Context, name string, primaryKey PrimaryKey, columnNames []string) (*GetRowResponse, error) {
+	primaryKey, err := c.normalizePrimaryKey(ctx, name, primaryKey, false)
+	if err != nil {
+		return nil, err
+	}
 	message, err := c.encoder.EncodeGetRow(name, primaryKey, columnNames)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("GetRow", message)
+	data, err := c.vist(ctx, "GetRow", message)
 	if err != nil {
 		return nil, err
 	}
 	return c.decoder.DecodeGetRow(data)
 }
 
-func (c *Client) PutRow(name string, condition *Condition, primaryKey map[string]interface{}, columns map[string]interface{}) (response *PutRowResponse, err error) {
+func (c *Client) PutRow(name string, condition *Condition, primaryKey PrimaryKey, columns map[string]interface{}) (response *PutRowResponse, err error) {
+	return c.PutRowWithContext(context.Background(), name, condition, primaryKey, columns)
+}
+
+// PutRowWithContext 同PutRow，ctx用于取消请求或设置超时
+func (c *Client) PutRowWithContext(ctx context.Context, name string, condition *Condition, primaryKey PrimaryKey, columns map[string]interface{}) (response *PutRowResponse, err error) {
+	primaryKey, err = c.normalizePrimaryKey(ctx, name, primaryKey, false)
+	if err != nil {
+		return nil, err
+	}
 	message, err := c.encoder.EncodePutRow(name, condition, primaryKey, columns)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("PutRow", message)
+	data, err := c.vist(ctx, "PutRow", message)
 	if err != nil {
 		return nil, err
 	}
 	return c.decoder.DecodePutRow(data)
 }
 
-func (c *Client) UpdateRow(name string, condition *Condition, primaryKey map[string]interface{}, columnsPut map[string]interface{}, columnsDelete []string) (*UpdateRowResponse, error) {
+func (c *Client) UpdateRow(name string, condition *Condition, primaryKey PrimaryKey, columnsPut map[string]interface{}, columnsDelete []string) (*UpdateRowResponse, error) {
+	return c.UpdateRowWithContext(context.Background(), name, condition, primaryKey, columnsPut, columnsDelete)
+}
+
+// UpdateRowWithContext 同UpdateRow，ctx用于取消请求或设置超时
+func (c *Client) UpdateRowWithContext(ctx context.Context, name string, condition *Condition, primaryKey PrimaryKey, columnsPut map[string]interface{}, columnsDelete []string) (*UpdateRowResponse, error) {
+	primaryKey, err := c.normalizePrimaryKey(ctx, name, primaryKey, false)
+	if err != nil {
+		return nil, err
+	}
 	message, err := c.encoder.EncodeUpdateRow(name, condition, primaryKey, columnsPut, columnsDelete)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("UpdateRow", message)
+	data, err := c.vist(ctx, "UpdateRow", message)
 	if err != nil {
 		return nil, err
 	}
 	return c.decoder.DecodeUpdateRow(data)
 }
 
-func (c *Client) DeleteRow(name string, condition *Condition, primaryKey map[string]interface{}) (*DeleteRowResponse, error) {
+func (c *Client) DeleteRow(name string, condition *Condition, primaryKey PrimaryKey) (*DeleteRowResponse, error) {
+	return c.DeleteRowWithContext(context.Background(), name, condition, primaryKey)
+}
+
+// DeleteRowWithContext 同DeleteRow，ctx用于取消请求或设置超时
+func (c *Client) DeleteRowWithContext(ctx context.Context, name string, condition *Condition, primaryKey PrimaryKey) (*DeleteRowResponse, error) {
+	primaryKey, err := c.normalizePrimaryKey(ctx, name, primaryKey, false)
+	if err != nil {
+		return nil, err
+	}
 	message, err := c.encoder.EncodeDeleteRow(name, condition, primaryKey)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("DeleteRow", message)
+	data, err := c.vist(ctx, "DeleteRow", message)
 	if err != nil {
 		return nil, err
 	}
 	return c.decoder.DecodeDeleteRow(data)
 }
 
+// BatchGetRow 方法用于批量读取多个表中的多行数据
+// 总行数超过BatchGetRowLimit时，请求会被拆分并以最多BatchGetRowParallelism的并发度发送，
+// 结果按表和请求中的顺序合并，返回的表按表名排序
 func (c *Client) BatchGetRow(items map[string]BatchGetRowItem) (*BatchGetRowResponse, error) {
-	message, err := c.encoder.EncodeBatchGetRow(items)
+	return c.BatchGetRowWithContext(context.Background(), items)
+}
+
+// BatchGetRowWithContext 同BatchGetRow，ctx用于取消请求或设置超时
+func (c *Client) BatchGetRowWithContext(ctx context.Context, items map[string]BatchGetRowItem) (*BatchGetRowResponse, error) {
+	items, err := c.normalizeBatchGetRowItems(ctx, items)
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.vist("BatchGetRow", message)
+	chunks := splitBatchGetRowItems(items, c.BatchGetRowLimit)
+	if len(chunks) <= 1 {
+		return c.batchGetRowWithRetry(ctx, items)
+	}
+	return c.dispatchBatchGetRow(ctx, items, chunks)
+}
+
+// BatchWriteRow 方法用于批量写入多个表中的多行数据
+// items: 表名到该表待写入行的映射
+// 返回结果中每张表的PutRows、UpdateRows、DeleteRows与请求中对应的行按下标一一对应，
+// 请求的条目可通过TableInBatchWriteRowResponse.Item获取
+// 示例:
+//
+// items := map[string]gots.BatchWriteRowItem{
+//      "sample_table": gots.BatchWriteRowItem{
+//              PutRows: []*gots.PutRowInBatchWriteRowItem{
+//                      &gots.PutRowInBatchWriteRowItem{
+//                              Condition:  &gots.Condition{RowExistence: gots.RowExistenceExpectationIgnore},
+//                              PrimaryKey: gots.NewPrimaryKey().Add("uid", 1),
+//                              Columns:    map[string]interface{}{"name": "gots"},
+//                      },
+//              },
+//      },
+// }
+// resp, err := client.BatchWriteRow(items)
+func (c *Client) BatchWriteRow(items map[string]BatchWriteRowItem) (*BatchWriteRowResponse, error) {
+	return c.BatchWriteRowWithContext(context.Background(), items)
+}
+
+// BatchWriteRowWithContext 同BatchWriteRow，ctx用于取消请求或设置超时
+func (c *Client) BatchWriteRowWithContext(ctx context.Context, items map[string]BatchWriteRowItem) (*BatchWriteRowResponse, error) {
+	items, err := c.normalizeBatchWriteRowItems(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	message, err := c.encoder.EncodeBatchWriteRow(items)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.vist(ctx, "BatchWriteRow", message)
 	if err != nil {
 		return nil, err
 	}
-	return c.decoder.DecodeBatchGetRow(data)
+	resp, err := c.decoder.DecodeBatchWriteRow(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.bind(items); err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
 
-// func (c *Client) BatchWriteRow(batchList []map[string]interface{}) (items []map[string]interface{}, err error) {
-// 	return nil, nil
-// }
+// GetRange 方法用于读取指定主键范围内的数据
+// 返回结果的NextStartPrimaryKey不为空时表示范围内还有数据，可将其作为下一次请求的起始主键继续读取
+// 示例:
 //
-// func (c *Client) GetRange(name string, direction Direction, incStartPrimaryKey *PrimaryKey, excEndPrimaryKey *PrimaryKey,
-// 	colums []string, limit int) (consumed *CapacityUnit, next []*PrimaryKey, rows []interface{}, err error) {
-// 	return nil, nil, nil, nil
+// req := &gots.GetRangeRequest{
+//      TableName:                "sample_table",
+//      Direction:                gots.DirectionForward,
+//      InclusiveStartPrimaryKey: gots.NewPrimaryKey().Add("gid", 1).Add("uid", gots.INFMin),
+//      ExclusiveEndPrimaryKey:   gots.NewPrimaryKey().Add("gid", 1).Add("uid", gots.INFMax),
+//      Limit:                    100,
 // }
-//
-// func (c *Client) XGetRange(name string, direction Direction, incStartPrimaryKey *PrimaryKey, excEndPrimaryKey *PrimaryKey,
-// 	consumedCounter *CapacityUnit, colums []string, limit int) (consumed *CapacityUnit, next []*PrimaryKey, rows []interface{}, err error) {
-// 	return nil, nil, nil, nil
+// resp, err := client.GetRange(req)
+// if err == nil && resp.HasNext() {
+//      req.InclusiveStartPrimaryKey = resp.NextStartPrimaryKey
 // }
+func (c *Client) GetRange(req *GetRangeRequest) (*GetRangeResponse, error) {
+	return c.GetRangeWithContext(context.Background(), req)
+}
+
+// GetRangeWithContext 同GetRange，ctx用于取消请求或设置超时
+func (c *Client) GetRangeWithContext(ctx context.Context, req *GetRangeRequest) (*GetRangeResponse, error) {
+	if c.CheckPrimaryKey {
+		start, err := c.normalizePrimaryKey(ctx, req.TableName, req.InclusiveStartPrimaryKey, true)
+		if err != nil {
+			return nil, err
+		}
+		end, err := c.normalizePrimaryKey(ctx, req.TableName, req.ExclusiveEndPrimaryKey, true)
+		if err != nil {
+			return nil, err
+		}
+		nreq := *req
+		nreq.InclusiveStartPrimaryKey = start
+		nreq.ExclusiveEndPrimaryKey = end
+		req = &nreq
+	}
+	message, err := c.encoder.EncodeGetRange(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.vist(ctx, "GetRange", message)
+	if err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeGetRange(data)
+}
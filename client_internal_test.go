@@ -0,0 +1,462 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// fakeHandler 处理一次请求，返回响应码和响应消息，响应码不为200时消息为*protobuf.Error
+type fakeHandler func(apiName string, body []byte) (int, proto.Message)
+
+// newFakeClient 返回连接到由handler处理请求的OTS服务的Client，测试结束时关闭服务
+func newFakeClient(t *testing.T, handler fakeHandler) *Client {
+	t.Helper()
+	client := NewClient("", "test_id", "test_key", "test_instance")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiName := strings.TrimPrefix(r.URL.Path, "/")
+		body, _ := ioutil.ReadAll(r.Body)
+		status, message := handler(apiName, body)
+		data, err := proto.Marshal(message)
+		if err != nil {
+			t.Errorf("marshal %s response: %v", apiName, err)
+		}
+		signFakeResponse(client.protocol, apiName, w.Header(), data)
+		w.WriteHeader(status)
+		w.Write(data)
+	}))
+	t.Cleanup(ts.Close)
+	client.EndPoint = ts.URL
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// signFakeResponse 使用p的凭证为响应添加x-ots-头和Authorization
+func signFakeResponse(p *Protocol, apiName string, header http.Header, body []byte) {
+	m := md5.Sum(body)
+	header.Set(HeaderOTSContentMd5, base64.StdEncoding.EncodeToString(m[:]))
+	header.Set(HeaderOTSDate, time.Now().UTC().Format(TimeFormat))
+	header.Set(HeaderOTSRequestID, "request-id")
+	header.Set(HeaderOTSContentType, "application/x-protobuf")
+	headers := make(map[string]string, len(header))
+	for k := range header {
+		headers[strings.ToLower(k)] = header.Get(k)
+	}
+	header.Set("Authorization", fmt.Sprintf("OTS %s:%s", p.AccessID, p.makeResponseSignature(p.AccessKey, "/"+apiName, headers)))
+}
+
+// fakeError 返回错误响应
+func fakeError(status int, code string) (int, proto.Message) {
+	return status, &protobuf.Error{Code: proto.String(code), Message: proto.String(code)}
+}
+
+func consumed(read, write int32) *protobuf.ConsumedCapacity {
+	return &protobuf.ConsumedCapacity{CapacityUnit: &protobuf.CapacityUnit{Read: proto.Int32(read), Write: proto.Int32(write)}}
+}
+
+func okRow() *protobuf.RowInBatchWriteRowResponse {
+	return &protobuf.RowInBatchWriteRowResponse{IsOk: proto.Bool(true)}
+}
+
+func TestBatchWriteRow(t *testing.T) {
+	var tables []string
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		req := &protobuf.BatchWriteRowRequest{}
+		if apiName != "BatchWriteRow" || proto.Unmarshal(body, req) != nil {
+			return fakeError(400, "OTSParameterInvalid")
+		}
+		resp := &protobuf.BatchWriteRowResponse{}
+		for _, table := range req.GetTables() {
+			tables = append(tables, table.GetTableName())
+			result := &protobuf.TableInBatchWriteRowResponse{TableName: table.TableName}
+			// 属性列name为conflict的行写入失败
+			for _, row := range table.GetPutRows() {
+				r := okRow()
+				if len(row.GetAttributeColumns()) > 0 && row.GetAttributeColumns()[0].GetValue().GetVString() == "conflict" {
+					r = &protobuf.RowInBatchWriteRowResponse{IsOk: proto.Bool(false), Error: &protobuf.Error{Code: proto.String("OTSConditionCheckFail")}}
+				}
+				result.PutRows = append(result.PutRows, r)
+			}
+			for range table.GetUpdateRows() {
+				result.UpdateRows = append(result.UpdateRows, okRow())
+			}
+			for range table.GetDeleteRows() {
+				result.DeleteRows = append(result.DeleteRows, okRow())
+			}
+			resp.Tables = append(resp.Tables, result)
+		}
+		return 200, resp
+	})
+
+	ignore := &Condition{RowExistence: RowExistenceExpectationIgnore}
+	items := map[string]BatchWriteRowItem{
+		"users": {
+			PutRows: []*PutRowInBatchWriteRowItem{
+				{Condition: ignore, PrimaryKey: NewPrimaryKey().Add("uid", 3), Columns: map[string]interface{}{"name": "new"}},
+				{Condition: ignore, PrimaryKey: NewPrimaryKey().Add("uid", 1), Columns: map[string]interface{}{"name": "conflict"}},
+			},
+			UpdateRows: []*UpdateRowInBatchWriteRowItem{
+				{Condition: ignore, PrimaryKey: NewPrimaryKey().Add("uid", 2), ColumnsPut: map[string]interface{}{"age": 20}, ColumnsDelete: []string{"name"}},
+			},
+			DeleteRows: []*DeleteRowInBatchWriteRowItem{
+				{Condition: ignore, PrimaryKey: NewPrimaryKey().Add("uid", 1)},
+			},
+		},
+		"groups": {
+			PutRows: []*PutRowInBatchWriteRowItem{
+				{Condition: ignore, PrimaryKey: NewPrimaryKey().Add("gid", 1), Columns: map[string]interface{}{"title": "admins"}},
+			},
+		},
+	}
+	resp, err := client.BatchWriteRow(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(tables) != "[groups users]" {
+		t.Errorf("request tables = %v, want sorted by name", tables)
+	}
+	users := resp.Table("users")
+	if users == nil || resp.Table("groups") == nil {
+		t.Fatalf("response tables = %v, want users and groups", resp.Tables)
+	}
+	if !users.PutRows[0].IsOk || !users.UpdateRows[0].IsOk || !users.DeleteRows[0].IsOk {
+		t.Errorf("users rows failed: %+v", users)
+	}
+	if users.PutRows[1].IsOk || users.PutRows[1].Error == nil || users.PutRows[1].Error.Code != "OTSConditionCheckFail" {
+		t.Errorf("conflicting put = %+v, want OTSConditionCheckFail", users.PutRows[1])
+	}
+	if users.Item.PutRows[1].Columns["name"] != "conflict" {
+		t.Errorf("response is not bound to the request item: %+v", users.Item)
+	}
+}
+
+func TestBatchWriteRowRowsMismatch(t *testing.T) {
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		return 200, &protobuf.BatchWriteRowResponse{Tables: []*protobuf.TableInBatchWriteRowResponse{
+			{TableName: proto.String("users")},
+		}}
+	})
+	items := map[string]BatchWriteRowItem{
+		"users": {DeleteRows: []*DeleteRowInBatchWriteRowItem{
+			{Condition: &Condition{RowExistence: RowExistenceExpectationIgnore}, PrimaryKey: NewPrimaryKey().Add("uid", 1)},
+		}},
+	}
+	if _, err := client.BatchWriteRow(items); err == nil || !strings.Contains(err.Error(), "Rows count mismatch for table users") {
+		t.Errorf("BatchWriteRow() = %v, want rows count mismatch", err)
+	}
+}
+
+func intColumn(name string, v int64) *protobuf.Column {
+	return &protobuf.Column{
+		Name:  proto.String(name),
+		Value: &protobuf.ColumnValue{Type: protobuf.ColumnType_INTEGER.Enum(), VInt: proto.Int64(v)},
+	}
+}
+
+// rangeBound 返回主键列uid的取值，INF_MIN和INF_MAX分别对应最小和最大的整数
+func rangeBound(cols []*protobuf.Column) int64 {
+	v := cols[0].GetValue()
+	switch v.GetType() {
+	case protobuf.ColumnType_INF_MIN:
+		return math.MinInt64
+	case protobuf.ColumnType_INF_MAX:
+		return math.MaxInt64
+	}
+	return v.GetVInt()
+}
+
+// fakeRangeHandler 处理主键为uid(1..count)、属性列为age=uid*10和name的表的GetRange请求，
+// 每次最多返回pageSize行，收到的请求记录在requests中
+func fakeRangeHandler(count, pageSize int, requests *[]*protobuf.GetRangeRequest) fakeHandler {
+	return func(apiName string, body []byte) (int, proto.Message) {
+		req := &protobuf.GetRangeRequest{}
+		if apiName != "GetRange" || proto.Unmarshal(body, req) != nil {
+			return fakeError(400, "OTSParameterInvalid")
+		}
+		*requests = append(*requests, req)
+		limit := pageSize
+		if req.Limit != nil && int(req.GetLimit()) < limit {
+			limit = int(req.GetLimit())
+		}
+		start, end := rangeBound(req.GetInclusiveStartPrimaryKey()), rangeBound(req.GetExclusiveEndPrimaryKey())
+		step := int64(1)
+		if req.GetDirection() == protobuf.Direction_BACKWARD {
+			step = -1
+		}
+		if start < 1 {
+			start = 1
+		} else if start > int64(count) {
+			start = int64(count)
+		}
+		resp := &protobuf.GetRangeResponse{Consumed: consumed(1, 0)}
+		for uid := start; uid >= 1 && uid <= int64(count) && (uid-end)*step < 0; uid += step {
+			if len(resp.Rows) == limit {
+				resp.NextStartPrimaryKey = []*protobuf.Column{intColumn("uid", uid)}
+				break
+			}
+			cols := []*protobuf.Column{intColumn("age", uid*10), {
+				Name:  proto.String("name"),
+				Value: &protobuf.ColumnValue{Type: protobuf.ColumnType_STRING.Enum(), VString: proto.String("user")},
+			}}
+			row := &protobuf.Row{PrimaryKeyColumns: []*protobuf.Column{intColumn("uid", uid)}}
+			for _, col := range cols {
+				for _, name := range req.GetColumnsToGet() {
+					if name == col.GetName() {
+						row.AttributeColumns = append(row.AttributeColumns, col)
+					}
+				}
+				if len(req.GetColumnsToGet()) == 0 {
+					row.AttributeColumns = append(row.AttributeColumns, col)
+				}
+			}
+			resp.Rows = append(resp.Rows, row)
+		}
+		return 200, resp
+	}
+}
+
+func rowUIDs(rows []*Row) []int64 {
+	uids := make([]int64, len(rows))
+	for i, row := range rows {
+		uids[i] = row.PrimaryKeyColumns[0].Value.VInt
+	}
+	return uids
+}
+
+func TestGetRange(t *testing.T) {
+	var requests []*protobuf.GetRangeRequest
+	client := newFakeClient(t, fakeRangeHandler(5, 100, &requests))
+
+	req := &GetRangeRequest{
+		TableName:                "users",
+		Direction:                DirectionForward,
+		InclusiveStartPrimaryKey: NewPrimaryKey().Add("uid", INFMin),
+		ExclusiveEndPrimaryKey:   NewPrimaryKey().Add("uid", INFMax),
+		Limit:                    2,
+	}
+	resp, err := client.GetRange(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uids := rowUIDs(resp.Rows); fmt.Sprint(uids) != "[1 2]" || !resp.HasNext() {
+		t.Fatalf("first page = %v, has next %v, want [1 2] and more", uids, resp.HasNext())
+	}
+	if resp.Consumed.CapacityUnit.Read != 1 {
+		t.Errorf("consumed = %+v, want read capacity", resp.Consumed.CapacityUnit)
+	}
+
+	req.InclusiveStartPrimaryKey = resp.NextStartPrimaryKey
+	req.Limit = 0
+	req.ColumnNames = []string{"age"}
+	resp, err = client.GetRange(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uids := rowUIDs(resp.Rows); fmt.Sprint(uids) != "[3 4 5]" || resp.HasNext() {
+		t.Fatalf("second page = %v, has next %v, want [3 4 5] and no more", uids, resp.HasNext())
+	}
+	for _, row := range resp.Rows {
+		if len(row.AttributeColumns) != 1 || row.AttributeColumns[0].Name != "age" {
+			t.Errorf("row %v columns = %v, want only age", row.PrimaryKeyColumns, row.AttributeColumns)
+		}
+	}
+	if requests[0].GetLimit() != 2 || requests[1].Limit != nil {
+		t.Errorf("limits = %v, %v, want 2 and unset", requests[0].Limit, requests[1].Limit)
+	}
+
+	backward := &GetRangeRequest{
+		TableName:                "users",
+		Direction:                DirectionBackward,
+		InclusiveStartPrimaryKey: NewPrimaryKey().Add("uid", 4),
+		ExclusiveEndPrimaryKey:   NewPrimaryKey().Add("uid", 1),
+	}
+	resp, err = client.GetRange(backward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uids := rowUIDs(resp.Rows); fmt.Sprint(uids) != "[4 3 2]" {
+		t.Errorf("backward range = %v, want [4 3 2]", uids)
+	}
+	if requests[2].GetDirection() != protobuf.Direction_BACKWARD {
+		t.Errorf("direction = %v, want BACKWARD", requests[2].GetDirection())
+	}
+}
+func TestNewTransport(t *testing.T) {
+	c := NewClient("http://127.0.0.1", "id", "key", "instance")
+	c.SocketTimeout = 2.5
+	c.MaxConnection = 7
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("transport = %T, want *http.Transport", c.httpClient.Transport)
+	}
+	if transport.MaxIdleConnsPerHost != 7 || transport.MaxConnsPerHost != 7 {
+		t.Errorf("connections per host = %d idle, %d max, want 7", transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+	if transport.ResponseHeaderTimeout != 2500*time.Millisecond || transport.TLSHandshakeTimeout != 2500*time.Millisecond {
+		t.Errorf("timeouts = %v, %v, want 2.5s", transport.ResponseHeaderTimeout, transport.TLSHandshakeTimeout)
+	}
+}
+
+func TestInitCustomTransport(t *testing.T) {
+	rt := &http.Transport{}
+	c := NewClient("http://127.0.0.1", "id", "key", "instance")
+	c.Transport = rt
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if c.httpClient.Transport != rt {
+		t.Errorf("Transport is not used")
+	}
+
+	hc := &http.Client{}
+	c.HTTPClient = hc
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if c.httpClient != hc {
+		t.Errorf("HTTPClient is not used")
+	}
+}
+
+func TestSocketTimeout(t *testing.T) {
+	c := NewClient(blockingServer(t).URL, "id", "key", "instance")
+	c.SocketTimeout = 0.05
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := c.ListTable(); err == nil {
+		t.Fatal("ListTable() succeeded on a server that never responds")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ListTable() took %v, want SocketTimeout to apply", elapsed)
+	}
+}
+
+func blockingServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() { close(release) })
+	return ts
+}
+
+type contextKey struct{}
+
+type recordingTransport struct {
+	value interface{}
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.value = req.Context().Value(contextKey{})
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestContextCanceled(t *testing.T) {
+	requests := 0
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		requests++
+		return fakeError(500, "OTSInternalServerError")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ignore := &Condition{RowExistence: RowExistenceExpectationIgnore}
+	pk := NewPrimaryKey().Add("uid", 1)
+	calls := []struct {
+		name string
+		call func() error
+	}{
+		{"ListTable", func() error { _, err := client.ListTableWithContext(ctx); return err }},
+		{"CreateTable", func() error {
+			_, err := client.CreateTableWithContext(ctx, "groups", []*ColumnSchema{{Name: "gid", Type: ColumnTypeInteger}},
+				&ReservedThroughput{CapacityUnit: &CapacityUnit{}})
+			return err
+		}},
+		{"DescribeTable", func() error { _, _, err := client.DescribeTableWithContext(ctx, "users"); return err }},
+		{"UpdateTable", func() error {
+			_, err := client.UpdateTableWithContext(ctx, "users", &ReservedThroughput{CapacityUnit: &CapacityUnit{Read: 1}})
+			return err
+		}},
+		{"GetRow", func() error { _, err := client.GetRowWithContext(ctx, "users", pk, nil); return err }},
+		{"PutRow", func() error { _, err := client.PutRowWithContext(ctx, "users", ignore, pk, nil); return err }},
+		{"UpdateRow", func() error {
+			_, err := client.UpdateRowWithContext(ctx, "users", ignore, pk, map[string]interface{}{"age": int64(1)}, nil)
+			return err
+		}},
+		{"DeleteRow", func() error { _, err := client.DeleteRowWithContext(ctx, "users", ignore, pk); return err }},
+		{"BatchGetRow", func() error {
+			_, err := client.BatchGetRowWithContext(ctx, map[string]BatchGetRowItem{"users": {PrimaryKeys: []PrimaryKey{pk}}})
+			return err
+		}},
+		{"BatchWriteRow", func() error {
+			_, err := client.BatchWriteRowWithContext(ctx, map[string]BatchWriteRowItem{"users": {
+				PutRows: []*PutRowInBatchWriteRowItem{{Condition: ignore, PrimaryKey: pk}},
+			}})
+			return err
+		}},
+		{"GetRange", func() error { _, err := client.GetRangeWithContext(ctx, fullRange()); return err }},
+		{"DeleteTable", func() error { _, err := client.DeleteTableWithContext(ctx, "users"); return err }},
+	}
+	for _, c := range calls {
+		if err := c.call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with canceled context = %v, want context.Canceled", c.name, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("%d requests sent with a canceled context", requests)
+	}
+}
+
+func TestContextPropagatesToRequest(t *testing.T) {
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		return 200, &protobuf.ListTableResponse{}
+	})
+	rt := &recordingTransport{}
+	client.Transport = rt
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), contextKey{}, "value")
+	if _, err := client.ListTableWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rt.value != "value" {
+		t.Errorf("request context value = %v, want value", rt.value)
+	}
+}
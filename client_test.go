@@ -0,0 +1,63 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Xuyuanp/gots"
+	"github.com/Xuyuanp/gots/gotstest"
+)
+
+const (
+	testAccessID     = "test_id"
+	testAccessKey    = "test_key"
+	testInstanceName = "test_instance"
+)
+
+// newTestClient 返回连接到内存OTS服务的Client，测试结束时关闭服务
+func newTestClient(t *testing.T) (*gots.Client, *gotstest.Server) {
+	t.Helper()
+	srv := gotstest.NewServer(testAccessID, testAccessKey, testInstanceName)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	client := gots.NewClient(ts.URL, testAccessID, testAccessKey, testInstanceName)
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return client, srv
+}
+
+// createTestTable 创建主键为(gid INTEGER, uid INTEGER)的表
+func createTestTable(t *testing.T, client *gots.Client, name string, read, write int32) {
+	t.Helper()
+	pks := []*gots.ColumnSchema{
+		{Name: "gid", Type: gots.ColumnTypeInteger},
+		{Name: "uid", Type: gots.ColumnTypeInteger},
+	}
+	rt := &gots.ReservedThroughput{CapacityUnit: &gots.CapacityUnit{Read: read, Write: write}}
+	if _, err := client.CreateTable(name, pks, rt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testPrimaryKey(gid, uid int64) gots.PrimaryKey {
+	return gots.NewPrimaryKey().Add("gid", gid).Add("uid", uid)
+}
+
+var ignore = &gots.Condition{RowExistence: gots.RowExistenceExpectationIgnore}
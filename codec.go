@@ -0,0 +1,347 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type fieldCodec struct {
+	index     int
+	name      string
+	pk        bool
+	omitEmpty bool
+	ptr       bool
+	kind      reflect.Kind
+}
+
+type structCodec struct {
+	fields     []*fieldCodec
+	primaryKey []*fieldCodec
+	byName     map[string]*fieldCodec
+}
+
+var structCodecs sync.Map
+
+func codecOf(t reflect.Type) (*structCodec, error) {
+	if c, ok := structCodecs.Load(t); ok {
+		return c.(*structCodec), nil
+	}
+	c, err := newStructCodec(t)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := structCodecs.LoadOrStore(t, c)
+	return actual.(*structCodec), nil
+}
+
+func newStructCodec(t reflect.Type) (*structCodec, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, &OTSClientError{Message: fmt.Sprintf("%s is not a struct", t)}
+	}
+	c := &structCodec{byName: make(map[string]*fieldCodec)}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("ots")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		f := &fieldCodec{index: i, name: opts[0]}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "pk":
+				f.pk = true
+			case "omitempty":
+				f.omitEmpty = true
+			default:
+				return nil, &OTSClientError{Message: fmt.Sprintf("Unknown ots tag option %q on field %s.%s", opt, t, sf.Name)}
+			}
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			f.ptr = true
+			ft = ft.Elem()
+		}
+		if !supportedKind(ft) {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Unsupported type %s of field %s.%s", sf.Type, t, sf.Name)}
+		}
+		f.kind = ft.Kind()
+		if _, ok := c.byName[f.name]; ok {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Duplicate column %s in %s", f.name, t)}
+		}
+		c.byName[f.name] = f
+		c.fields = append(c.fields, f)
+		if f.pk {
+			c.primaryKey = append(c.primaryKey, f)
+		}
+	}
+	return c, nil
+}
+
+func supportedKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+func structValue(v interface{}) (reflect.Value, *structCodec, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}, nil, &OTSClientError{Message: "Marshal nil pointer"}
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return reflect.Value{}, nil, &OTSClientError{Message: "Marshal nil value"}
+	}
+	c, err := codecOf(rv.Type())
+	if err != nil {
+		return reflect.Value{}, nil, err
+	}
+	return rv, c, nil
+}
+
+// columnValue 返回字段对应的列值，字段为nil指针时返回nil
+func (f *fieldCodec) columnValue(rv reflect.Value) interface{} {
+	fv := rv.Field(f.index)
+	if f.ptr {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	}
+	switch f.kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fv.Int()
+	case reflect.Float32, reflect.Float64:
+		return fv.Float()
+	case reflect.Bool:
+		return fv.Bool()
+	case reflect.String:
+		return fv.String()
+	default:
+		return fv.Bytes()
+	}
+}
+
+func (c *structCodec) marshalPrimaryKey(rv reflect.Value) (PrimaryKey, error) {
+	if len(c.primaryKey) == 0 {
+		return nil, &OTSClientError{Message: fmt.Sprintf("No primary key field in %s", rv.Type())}
+	}
+	pk := NewPrimaryKey()
+	for _, f := range c.primaryKey {
+		v := f.columnValue(rv)
+		if v == nil {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Primary key column %s of %s is nil", f.name, rv.Type())}
+		}
+		pk = pk.Add(f.name, v)
+	}
+	return pk, nil
+}
+
+// MarshalPrimaryKey 返回结构体中标记为pk的字段组成的主键，用于GetRow和DeleteRow
+func MarshalPrimaryKey(v interface{}) (PrimaryKey, error) {
+	rv, c, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.marshalPrimaryKey(rv)
+}
+
+// Marshal 将结构体转换为主键和属性列，用于PutRow。
+// 值为nil的指针字段以及带有omitempty选项的零值字段不会被写入
+//
+// 结构体字段通过ots标签映射为列，格式为`ots:"列名,选项"`，列名为空时使用字段名，"-"表示忽略该字段。
+// 支持的选项:
+//
+//	pk        该字段为主键列，主键列的顺序即字段在结构体中定义的顺序
+//	omitempty 字段为零值时不写入该列
+//
+// 支持的字段类型为int*、float*、bool、string、[]byte以及指向这些类型的指针。
+// 示例:
+//
+//	type User struct {
+//		GID   int64    `ots:"gid,pk"`
+//		UID   int64    `ots:"uid,pk"`
+//		Name  string   `ots:"name,omitempty"`
+//		Score *float64 `ots:"score"`
+//	}
+func Marshal(v interface{}) (PrimaryKey, map[string]interface{}, error) {
+	pk, columns, _, err := marshal(v)
+	return pk, columns, err
+}
+
+// MarshalUpdate 将结构体转换为主键、待写入的列和待删除的列，用于UpdateRow。
+// 值为nil且没有omitempty选项的指针字段对应的列会被删除
+func MarshalUpdate(v interface{}) (PrimaryKey, map[string]interface{}, []string, error) {
+	return marshal(v)
+}
+
+func marshal(v interface{}) (pk PrimaryKey, columns map[string]interface{}, deletes []string, err error) {
+	rv, c, err := structValue(v)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pk, err = c.marshalPrimaryKey(rv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	columns = make(map[string]interface{}, len(c.fields)-len(c.primaryKey))
+	for _, f := range c.fields {
+		if f.pk {
+			continue
+		}
+		if f.omitEmpty && rv.Field(f.index).IsZero() {
+			continue
+		}
+		value := f.columnValue(rv)
+		if value == nil {
+			deletes = append(deletes, f.name)
+			continue
+		}
+		columns[f.name] = value
+	}
+	return pk, columns, deletes, nil
+}
+
+// Unmarshal 将行中的列解码到v指向的结构体中，结构体中没有对应字段的列会被忽略
+func Unmarshal(row *Row, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &OTSClientError{Message: "Unmarshal requires a non-nil pointer"}
+	}
+	rv = rv.Elem()
+	c, err := codecOf(rv.Type())
+	if err != nil {
+		return err
+	}
+	return c.unmarshal(row, rv)
+}
+
+// UnmarshalRows 将多行解码到slicePtr指向的结构体切片中，切片元素可以是结构体或结构体指针
+func UnmarshalRows(rows []*Row, slicePtr interface{}) error {
+	sv := reflect.ValueOf(slicePtr)
+	if sv.Kind() != reflect.Ptr || sv.IsNil() || sv.Elem().Kind() != reflect.Slice {
+		return &OTSClientError{Message: "UnmarshalRows requires a non-nil pointer to slice"}
+	}
+	sv = sv.Elem()
+	et := sv.Type().Elem()
+	isPtr := et.Kind() == reflect.Ptr
+	if isPtr {
+		et = et.Elem()
+	}
+	c, err := codecOf(et)
+	if err != nil {
+		return err
+	}
+	result := reflect.MakeSlice(sv.Type(), len(rows), len(rows))
+	for i, row := range rows {
+		ev := reflect.New(et).Elem()
+		if err := c.unmarshal(row, ev); err != nil {
+			return err
+		}
+		if isPtr {
+			result.Index(i).Set(ev.Addr())
+		} else {
+			result.Index(i).Set(ev)
+		}
+	}
+	sv.Set(result)
+	return nil
+}
+
+func (c *structCodec) unmarshal(row *Row, rv reflect.Value) error {
+	if row == nil {
+		return nil
+	}
+	for _, cols := range [][]*Column{row.PrimaryKeyColumns, row.AttributeColumns} {
+		for _, col := range cols {
+			f, ok := c.byName[col.Name]
+			if !ok || col.Value == nil {
+				continue
+			}
+			if err := f.set(rv, col.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fieldCodec) set(rv reflect.Value, cv *ColumnValue) error {
+	fv := rv.Field(f.index)
+	if f.ptr {
+		nv := reflect.New(fv.Type().Elem())
+		fv.Set(nv)
+		fv = nv.Elem()
+	}
+	mismatch := func() error {
+		return &OTSClientError{Message: fmt.Sprintf("Cannot decode %s column %s into %s field %s.%s",
+			cv.Type, f.name, fv.Type(), rv.Type(), rv.Type().Field(f.index).Name)}
+	}
+	switch f.kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if cv.Type != ColumnTypeInteger {
+			return mismatch()
+		}
+		if fv.OverflowInt(cv.VInt) {
+			return &OTSClientError{Message: fmt.Sprintf("Value %d of column %s overflows %s", cv.VInt, f.name, fv.Type())}
+		}
+		fv.SetInt(cv.VInt)
+	case reflect.Float32, reflect.Float64:
+		if cv.Type != ColumnTypeDouble {
+			return mismatch()
+		}
+		if fv.Kind() == reflect.Float32 && math.Abs(cv.VDouble) > math.MaxFloat32 {
+			return &OTSClientError{Message: fmt.Sprintf("Value %g of column %s overflows %s", cv.VDouble, f.name, fv.Type())}
+		}
+		fv.SetFloat(cv.VDouble)
+	case reflect.Bool:
+		if cv.Type != ColumnTypeBoolean {
+			return mismatch()
+		}
+		fv.SetBool(cv.VBool)
+	case reflect.String:
+		if cv.Type != ColumnTypeString {
+			return mismatch()
+		}
+		fv.SetString(cv.VString)
+	default:
+		if cv.Type != ColumnTypeBinary {
+			return mismatch()
+		}
+		fv.SetBytes(append([]byte(nil), cv.VBinary...))
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type codecUser struct {
+	GID     int64    `ots:"gid,pk"`
+	UID     int32    `ots:"uid,pk"`
+	Name    string   `ots:"name,omitempty"`
+	Age     int8     `ots:"age"`
+	Score   *float64 `ots:"score"`
+	Ratio   float32  `ots:"ratio"`
+	Active  bool     `ots:"active"`
+	Avatar  []byte   `ots:"avatar,omitempty"`
+	Nick    *string  `ots:"nick,omitempty"`
+	Ignored string   `ots:"-"`
+	Default string
+	private string
+}
+
+// codecRow 将Marshal的结果组装为行，模拟服务端返回的数据
+func codecRow(pk PrimaryKey, columns map[string]interface{}) *Row {
+	row := &Row{PrimaryKeyColumns: pk}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		row.AttributeColumns = append(row.AttributeColumns, &Column{Name: name, Value: NewColumnValue(columns[name])})
+	}
+	return row
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	score, nick := 9.5, "bob"
+	in := codecUser{
+		GID: 1, UID: 2, Name: "user", Age: 30, Score: &score, Ratio: 0.5,
+		Active: true, Avatar: []byte{1, 2}, Nick: &nick, Ignored: "x", Default: "d", private: "p",
+	}
+	pk, columns, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pk) != 2 || pk[0].Name != "gid" || pk[1].Name != "uid" || pk[1].Value.VInt != 2 {
+		t.Fatalf("primary key = %v, want gid and uid in field order", pk)
+	}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "Default active age avatar name nick ratio score" {
+		t.Errorf("columns = %s, want exported non-pk fields", got)
+	}
+
+	var out codecUser
+	if err := Unmarshal(codecRow(pk, columns), &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Ignored, in.private = "", ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Unmarshal() = %+v, want %+v", out, in)
+	}
+	if out.Score == &score || out.Nick == &nick {
+		t.Error("Unmarshal() reused the pointers of the marshaled value")
+	}
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	pk, columns, err := Marshal(codecUser{GID: 1, UID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"name", "avatar", "nick", "score"} {
+		if _, ok := columns[name]; ok {
+			t.Errorf("column %s written, want it omitted", name)
+		}
+	}
+	if v, ok := columns["age"]; !ok || v != int64(0) {
+		t.Errorf("age = %v, want zero value written without omitempty", v)
+	}
+	if len(pk) != 2 {
+		t.Errorf("primary key = %v, want 2 columns", pk)
+	}
+}
+
+func TestMarshalUpdate(t *testing.T) {
+	_, columns, deletes, err := MarshalUpdate(&codecUser{GID: 1, UID: 2, Name: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deletes, " ") != "score" {
+		t.Errorf("deletes = %v, want nil pointers without omitempty deleted", deletes)
+	}
+	if columns["name"] != "user" {
+		t.Errorf("name = %v, want user", columns["name"])
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		err  string
+	}{
+		{"nil pointer", (*codecUser)(nil), "nil pointer"},
+		{"not struct", 1, "is not a struct"},
+		{"no primary key", struct {
+			Name string `ots:"name"`
+		}{}, "No primary key field"},
+		{"nil primary key", struct {
+			ID *int64 `ots:"id,pk"`
+		}{}, "is nil"},
+		{"unknown option", struct {
+			ID int64 `ots:"id,pk,index"`
+		}{}, `Unknown ots tag option "index"`},
+		{"unsupported type", struct {
+			ID   int64   `ots:"id,pk"`
+			Tags []int64 `ots:"tags"`
+		}{}, "Unsupported type []int64"},
+		{"duplicate column", struct {
+			ID   int64  `ots:"id,pk"`
+			Name string `ots:"id"`
+		}{}, "Duplicate column id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Marshal(tt.v)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Marshal() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	row := func(name string, value interface{}) *Row {
+		return &Row{AttributeColumns: []*Column{{Name: name, Value: NewColumnValue(value)}}}
+	}
+	var u codecUser
+	if err := Unmarshal(row("age", "30"), &u); err == nil || !strings.Contains(err.Error(), "Cannot decode STRING column age") {
+		t.Errorf("Unmarshal() type mismatch error = %v", err)
+	}
+	if err := Unmarshal(row("age", int64(300)), &u); err == nil || !strings.Contains(err.Error(), "overflows int8") {
+		t.Errorf("Unmarshal() int overflow error = %v", err)
+	}
+	if err := Unmarshal(row("ratio", 1e40), &u); err == nil || !strings.Contains(err.Error(), "overflows float32") {
+		t.Errorf("Unmarshal() float overflow error = %v", err)
+	}
+	if err := Unmarshal(row("name", "a"), u); err == nil {
+		t.Error("Unmarshal() into a non-pointer succeeded")
+	}
+	if err := Unmarshal(row("unknown", "a"), &u); err != nil {
+		t.Errorf("Unmarshal() with an unknown column = %v, want it ignored", err)
+	}
+}
+
+func TestUnmarshalRows(t *testing.T) {
+	rows := []*Row{
+		{PrimaryKeyColumns: NewPrimaryKey().Add("gid", 1).Add("uid", 1), AttributeColumns: []*Column{{Name: "avatar", Value: NewColumnValue([]byte("a"))}}},
+		{PrimaryKeyColumns: NewPrimaryKey().Add("gid", 1).Add("uid", 2)},
+	}
+	var values []codecUser
+	if err := UnmarshalRows(rows, &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0].UID != 1 || values[1].UID != 2 || !bytes.Equal(values[0].Avatar, []byte("a")) {
+		t.Errorf("UnmarshalRows() = %+v", values)
+	}
+	var ptrs []*codecUser
+	if err := UnmarshalRows(rows, &ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 2 || ptrs[1].UID != 2 {
+		t.Errorf("UnmarshalRows() into pointers = %+v", ptrs)
+	}
+	if err := UnmarshalRows(rows, values); err == nil {
+		t.Error("UnmarshalRows() into a non-pointer succeeded")
+	}
+}
+
+func TestCodecCached(t *testing.T) {
+	typ := reflect.TypeOf(codecUser{})
+	c1, err := codecOf(typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := codecOf(typ)
+	if c1 != c2 {
+		t.Error("codecOf() built a new codec for a cached type")
+	}
+}
@@ -0,0 +1,442 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 凭证相关的环境变量
+const (
+	EnvAccessKeyID     = "OTS_ACCESS_KEY_ID"
+	EnvAccessKeySecret = "OTS_ACCESS_KEY_SECRET"
+	EnvSecurityToken   = "OTS_SECURITY_TOKEN"
+	EnvProfile         = "OTS_PROFILE"
+	EnvCredentialsFile = "OTS_CREDENTIALS_FILE"
+)
+
+const (
+	// DefaultProfile 为配置文件中默认使用的profile
+	DefaultProfile = "default"
+	// DefaultRefreshWindow 为临时凭证过期前提前刷新的时间
+	DefaultRefreshWindow = 5 * time.Minute
+	// DefaultRefreshTimeout 为单次刷新凭证的超时时间
+	DefaultRefreshTimeout = time.Minute
+	// DefaultRefreshRetryInterval 为刷新失败后到下一次刷新的初始间隔
+	DefaultRefreshRetryInterval = 5 * time.Second
+
+	// maxRefreshRetryShift 限制连续刷新失败时间隔翻倍的次数
+	maxRefreshRetryShift = 5
+)
+
+// Credentials 为签名请求使用的访问凭证。
+// SecurityToken 不为空时为STS临时凭证，请求会带上x-ots-ststoken头
+type Credentials struct {
+	AccessID      string
+	AccessKey     string
+	SecurityToken string
+	// Expiration 为临时凭证的过期时间，零值表示永不过期
+	Expiration time.Time
+}
+
+// Expired 返回凭证在now时是否已过期
+func (c *Credentials) Expired(now time.Time) bool {
+	return !c.Expiration.IsZero() && !now.Before(c.Expiration)
+}
+
+func (c *Credentials) valid() bool {
+	return c.AccessID != "" && c.AccessKey != ""
+}
+
+// CredentialsProvider 为访问凭证的来源，Protocol在每次签名请求前调用Credentials获取凭证，
+// 实现需要并发安全
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+}
+
+// CredentialsProviderFunc 将函数适配为CredentialsProvider
+type CredentialsProviderFunc func(ctx context.Context) (*Credentials, error)
+
+// Credentials 调用f
+func (f CredentialsProviderFunc) Credentials(ctx context.Context) (*Credentials, error) {
+	return f(ctx)
+}
+
+// StaticCredentialsProvider 始终返回固定的凭证
+type StaticCredentialsProvider struct {
+	Value Credentials
+}
+
+// NewStaticCredentialsProvider 使用AccessID、AccessKey和可选的SecurityToken创建StaticCredentialsProvider
+func NewStaticCredentialsProvider(accessID, accessKey, securityToken string) *StaticCredentialsProvider {
+	return &StaticCredentialsProvider{
+		Value: Credentials{
+			AccessID:      accessID,
+			AccessKey:     accessKey,
+			SecurityToken: securityToken,
+		},
+	}
+}
+
+// Credentials 返回固定的凭证
+func (p *StaticCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	if !p.Value.valid() {
+		return nil, &OTSClientError{Message: "Static credentials are empty"}
+	}
+	creds := p.Value
+	return &creds, nil
+}
+
+// EnvCredentialsProvider 从环境变量OTS_ACCESS_KEY_ID、OTS_ACCESS_KEY_SECRET和OTS_SECURITY_TOKEN读取凭证，
+// 每次调用都会重新读取
+type EnvCredentialsProvider struct{}
+
+// Credentials 从环境变量读取凭证
+func (p *EnvCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	creds := &Credentials{
+		AccessID:      os.Getenv(EnvAccessKeyID),
+		AccessKey:     os.Getenv(EnvAccessKeySecret),
+		SecurityToken: os.Getenv(EnvSecurityToken),
+	}
+	if !creds.valid() {
+		return nil, &OTSClientError{Message: fmt.Sprintf("%s or %s is not set", EnvAccessKeyID, EnvAccessKeySecret)}
+	}
+	return creds, nil
+}
+
+// ProfileCredentialsProvider 从INI格式的配置文件读取凭证，格式如下：
+//
+//	[default]
+//	access_key_id = your_access_id
+//	access_key_secret = your_access_key
+//	security_token = optional_sts_token
+//
+// 文件修改后会在下一次调用时重新读取
+type ProfileCredentialsProvider struct {
+	// Path 为配置文件路径，为空时使用环境变量OTS_CREDENTIALS_FILE，否则为~/.ots/credentials
+	Path string
+	// Profile 为使用的profile，为空时使用环境变量OTS_PROFILE，否则为default
+	Profile string
+
+	mu      sync.Mutex
+	modTime time.Time
+	creds   *Credentials
+}
+
+func (p *ProfileCredentialsProvider) path() (string, error) {
+	if p.Path != "" {
+		return p.Path, nil
+	}
+	if path := os.Getenv(EnvCredentialsFile); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ots", "credentials"), nil
+}
+
+func (p *ProfileCredentialsProvider) profile() string {
+	if p.Profile != "" {
+		return p.Profile
+	}
+	if profile := os.Getenv(EnvProfile); profile != "" {
+		return profile
+	}
+	return DefaultProfile
+}
+
+// Credentials 从配置文件读取凭证
+func (p *ProfileCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	path, err := p.path()
+	if err != nil {
+		return nil, &OTSClientError{Message: "Locate credentials file failed", Err: err}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Stat credentials file %s failed", path), Err: err}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.creds != nil && info.ModTime().Equal(p.modTime) {
+		creds := *p.creds
+		return &creds, nil
+	}
+
+	profile := p.profile()
+	creds, err := loadProfile(path, profile)
+	if err != nil {
+		return nil, err
+	}
+	p.creds = creds
+	p.modTime = info.ModTime()
+	c := *creds
+	return &c, nil
+}
+
+// loadProfile 从INI文件中读取指定profile的凭证
+func loadProfile(path, profile string) (*Credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Open credentials file %s failed", path), Err: err}
+	}
+	defer f.Close()
+
+	var (
+		section string
+		found   bool
+		creds   Credentials
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == profile {
+				found = true
+			}
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "access_key_id":
+			creds.AccessID = value
+		case "access_key_secret":
+			creds.AccessKey = value
+		case "security_token":
+			creds.SecurityToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Read credentials file %s failed", path), Err: err}
+	}
+	if !found {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Profile %s not found in %s", profile, path)}
+	}
+	if !creds.valid() {
+		return nil, &OTSClientError{Message: fmt.Sprintf("access_key_id or access_key_secret is missing in profile %s", profile)}
+	}
+	return &creds, nil
+}
+
+// ChainCredentialsProvider 依次尝试Providers，返回第一个成功获取的凭证。
+// 成功的Provider会被记住，之后优先使用，失败时再从头尝试
+type ChainCredentialsProvider struct {
+	Providers []CredentialsProvider
+
+	mu      sync.Mutex
+	current CredentialsProvider
+}
+
+// NewDefaultCredentialsProvider 返回依次从环境变量和配置文件读取凭证的ChainCredentialsProvider
+func NewDefaultCredentialsProvider() *ChainCredentialsProvider {
+	return &ChainCredentialsProvider{
+		Providers: []CredentialsProvider{
+			&EnvCredentialsProvider{},
+			&ProfileCredentialsProvider{},
+		},
+	}
+}
+
+// Credentials 返回第一个成功获取的凭证，全部失败时返回汇总的错误
+func (p *ChainCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	p.mu.Lock()
+	current := p.current
+	p.mu.Unlock()
+	if current != nil {
+		if creds, err := current.Credentials(ctx); err == nil {
+			return creds, nil
+		}
+	}
+
+	messages := make([]string, 0, len(p.Providers))
+	for _, provider := range p.Providers {
+		creds, err := provider.Credentials(ctx)
+		if err != nil {
+			messages = append(messages, err.Error())
+			continue
+		}
+		p.mu.Lock()
+		p.current = provider
+		p.mu.Unlock()
+		return creds, nil
+	}
+	return nil, &OTSClientError{Message: fmt.Sprintf("No valid credentials in chain: %s", strings.Join(messages, "; "))}
+}
+
+// RefreshingCredentialsProvider 缓存Fetch返回的临时凭证，在过期前RefreshWindow时重新获取。
+// 同一时间只有一个刷新在进行，旧凭证尚未过期时请求不等待刷新而是继续使用旧凭证。
+// 刷新失败后在RetryInterval内不再刷新，连续失败时间隔翻倍
+type RefreshingCredentialsProvider struct {
+	// Fetch 获取新的凭证，通常是调用STS AssumeRole。
+	// ctx与发起请求的ctx无关，超时时间为RefreshTimeout
+	Fetch func(ctx context.Context) (*Credentials, error)
+	// RefreshWindow 为过期前提前刷新的时间，为0时使用DefaultRefreshWindow
+	RefreshWindow time.Duration
+	// Jitter 不为0时，每份凭证的刷新时间再随机提前[0, Jitter)，避免大量进程同时刷新
+	Jitter time.Duration
+	// RefreshTimeout 为单次刷新的超时时间，为0时使用DefaultRefreshTimeout
+	RefreshTimeout time.Duration
+	// RetryInterval 为刷新失败后的初始重试间隔，为0时使用DefaultRefreshRetryInterval
+	RetryInterval time.Duration
+
+	mu         sync.Mutex
+	creds      *Credentials
+	refreshAt  time.Time
+	refreshing chan struct{}
+	lastErr    error
+	failures   int
+	retryAt    time.Time
+}
+
+func (p *RefreshingCredentialsProvider) refreshWindow() time.Duration {
+	if p.RefreshWindow > 0 {
+		return p.RefreshWindow
+	}
+	return DefaultRefreshWindow
+}
+
+func (p *RefreshingCredentialsProvider) refreshTimeout() time.Duration {
+	if p.RefreshTimeout > 0 {
+		return p.RefreshTimeout
+	}
+	return DefaultRefreshTimeout
+}
+
+func (p *RefreshingCredentialsProvider) retryInterval() time.Duration {
+	interval := p.RetryInterval
+	if interval <= 0 {
+		interval = DefaultRefreshRetryInterval
+	}
+	shift := p.failures - 1
+	if shift > maxRefreshRetryShift {
+		shift = maxRefreshRetryShift
+	}
+	return interval << uint(shift)
+}
+
+// usable 返回尚未过期的缓存凭证的副本，需要持有p.mu
+func (p *RefreshingCredentialsProvider) usable(now time.Time) *Credentials {
+	if p.creds == nil || p.creds.Expired(now) {
+		return nil
+	}
+	creds := *p.creds
+	return &creds
+}
+
+// Credentials 返回缓存的凭证，临近过期时刷新
+func (p *RefreshingCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	p.mu.Lock()
+	now := time.Now()
+	if p.creds != nil && (p.creds.Expiration.IsZero() || now.Before(p.refreshAt)) {
+		creds := *p.creds
+		p.mu.Unlock()
+		return &creds, nil
+	}
+
+	if p.refreshing == nil {
+		if now.Before(p.retryAt) {
+			defer p.mu.Unlock()
+			if creds := p.usable(now); creds != nil {
+				return creds, nil
+			}
+			return nil, p.lastErr
+		}
+		p.refreshing = make(chan struct{})
+		go p.refresh(p.refreshing)
+	}
+	if creds := p.usable(now); creds != nil {
+		p.mu.Unlock()
+		return creds, nil
+	}
+	done := p.refreshing
+	p.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, &OTSClientError{Message: "Wait for credentials refresh failed", Err: ctx.Err()}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if creds := p.usable(time.Now()); creds != nil {
+		return creds, nil
+	}
+	if p.lastErr != nil {
+		return nil, p.lastErr
+	}
+	return nil, &OTSClientError{Message: "Credentials are not available"}
+}
+
+// refresh 调用Fetch更新缓存的凭证，完成后关闭done
+func (p *RefreshingCredentialsProvider) refresh(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.refreshTimeout())
+	defer cancel()
+	fresh, err := p.Fetch(ctx)
+	if err == nil && (fresh == nil || !fresh.valid()) {
+		err = &OTSClientError{Message: "Refreshed credentials are empty"}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer close(done)
+	p.refreshing = nil
+	if err != nil {
+		p.lastErr = err
+		p.failures++
+		p.retryAt = time.Now().Add(p.retryInterval())
+		return
+	}
+	c := *fresh
+	p.creds = &c
+	p.lastErr = nil
+	p.failures = 0
+	p.retryAt = time.Time{}
+	p.refreshAt = c.Expiration.Add(-p.refreshWindow())
+	if p.Jitter > 0 {
+		p.refreshAt = p.refreshAt.Add(-time.Duration(rand.Int63n(int64(p.Jitter))))
+	}
+}
+
+// Invalidate 丢弃缓存的凭证，下一次调用Credentials时重新获取
+func (p *RefreshingCredentialsProvider) Invalidate() {
+	p.mu.Lock()
+	p.creds = nil
+	p.retryAt = time.Time{}
+	p.mu.Unlock()
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshingCredentialsSingleFlight(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	p := &RefreshingCredentialsProvider{Fetch: func(ctx context.Context) (*Credentials, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return &Credentials{AccessID: "id", AccessKey: "key", Expiration: time.Now().Add(time.Hour)}, nil
+	}}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			creds, err := p.Credentials(context.Background())
+			if err == nil && creds.AccessID != "id" {
+				err = errors.New("unexpected credentials")
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Fetch called %d times, want 1", n)
+	}
+}
+
+func TestRefreshingCredentialsKeepsOldWhileRefreshing(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	p := &RefreshingCredentialsProvider{Fetch: func(ctx context.Context) (*Credentials, error) {
+		<-release
+		return &Credentials{AccessID: "new", AccessKey: "key"}, nil
+	}}
+	p.creds = &Credentials{AccessID: "old", AccessKey: "key", Expiration: time.Now().Add(time.Minute)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	creds, err := p.Credentials(ctx)
+	if err != nil || creds.AccessID != "old" {
+		t.Fatalf("Credentials() = %v, %v, want old credentials without waiting", creds, err)
+	}
+}
+
+func TestRefreshingCredentialsDetachedContext(t *testing.T) {
+	release := make(chan struct{})
+	fetchErr := make(chan error, 1)
+	p := &RefreshingCredentialsProvider{Fetch: func(ctx context.Context) (*Credentials, error) {
+		<-release
+		fetchErr <- ctx.Err()
+		return &Credentials{AccessID: "id", AccessKey: "key"}, nil
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.Credentials(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Credentials(canceled) = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-fetchErr; err != nil {
+		t.Fatalf("Fetch ctx canceled with caller: %v", err)
+	}
+	if creds, err := p.Credentials(context.Background()); err != nil || creds.AccessID != "id" {
+		t.Fatalf("Credentials() = %v, %v", creds, err)
+	}
+}
+
+func TestRefreshingCredentialsRetryBackoff(t *testing.T) {
+	var fetches int32
+	fetchErr := errors.New("sts unavailable")
+	p := &RefreshingCredentialsProvider{
+		RetryInterval: 20 * time.Millisecond,
+		Fetch: func(ctx context.Context) (*Credentials, error) {
+			atomic.AddInt32(&fetches, 1)
+			return nil, fetchErr
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Credentials(context.Background()); err != fetchErr {
+			t.Fatalf("Credentials() = %v, want %v", err, fetchErr)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("Fetch called %d times within retry interval, want 1", n)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	p.Credentials(context.Background())
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("Fetch called %d times after retry interval, want 2", n)
+	}
+	if got := p.retryInterval(); got != 40*time.Millisecond {
+		t.Errorf("retry interval after 2 failures = %v, want 40ms", got)
+	}
+}
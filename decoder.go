@@ -125,3 +125,23 @@ func (d *Decoder) DecodeBatchGetRow(data []byte) (*BatchGetRowResponse, error) {
 	bgrr := (&BatchGetRowResponse{}).Parse(pbBGRR)
 	return bgrr, nil
 }
+
+func (d *Decoder) DecodeBatchWriteRow(data []byte) (*BatchWriteRowResponse, error) {
+	pbBWRR := &protobuf.BatchWriteRowResponse{}
+	err := proto.Unmarshal(data, pbBWRR)
+	if err != nil {
+		return nil, err
+	}
+	bwrr := (&BatchWriteRowResponse{}).Parse(pbBWRR)
+	return bwrr, nil
+}
+
+func (d *Decoder) DecodeGetRange(data []byte) (*GetRangeResponse, error) {
+	pbGRR := &protobuf.GetRangeResponse{}
+	err := proto.Unmarshal(data, pbGRR)
+	if err != nil {
+		return nil, err
+	}
+	grr := (&GetRangeResponse{}).Parse(pbGRR)
+	return grr, nil
+}
@@ -17,6 +17,8 @@
 package gots
 
 import (
+	"sort"
+
 	"github.com/Xuyuanp/gots/protobuf"
 	"github.com/golang/protobuf/proto"
 )
@@ -65,63 +67,58 @@ func (e *Encoder) EncodeDeleteTable(name string) (proto.Message, error) {
 	return dtr, nil
 }
 
-func (e *Encoder) EncodeGetRow(name string, primaryKey map[string]interface{}, columnNames []string) (proto.Message, error) {
-	pk := ColumnsFromMap(primaryKey)
+func (e *Encoder) EncodeGetRow(name string, primaryKey PrimaryKey, columnNames []string) (proto.Message, error) {
 	pbGRR := &protobuf.GetRowRequest{
 		TableName:    new(string),
-		PrimaryKey:   make([]*protobuf.Column, len(pk)),
+		PrimaryKey:   primaryKey.Unparse(),
 		ColumnsToGet: columnNames,
 	}
 	*pbGRR.TableName = name
-	for i, p := range pk {
-		pbGRR.PrimaryKey[i] = p.Unparse()
-	}
 	return pbGRR, nil
 }
 
-func (e *Encoder) EncodePutRow(name string, condition *Condition, primaryKey map[string]interface{}, columns map[string]interface{}) (proto.Message, error) {
-	pk := ColumnsFromMap(primaryKey)
-	cols := ColumnsFromMap(columns)
+func (e *Encoder) EncodePutRow(name string, condition *Condition, primaryKey PrimaryKey, columns map[string]interface{}) (proto.Message, error) {
 	pbPR := &protobuf.PutRowRequest{
 		TableName:        new(string),
 		Condition:        condition.Unparse(),
-		PrimaryKey:       make([]*protobuf.Column, len(pk)),
-		AttributeColumns: make([]*protobuf.Column, len(cols)),
+		PrimaryKey:       primaryKey.Unparse(),
+		AttributeColumns: unparseColumns(columns),
 	}
 	*pbPR.TableName = name
-	for i, p := range pk {
-		pbPR.PrimaryKey[i] = p.Unparse()
-	}
-	for i, col := range cols {
-		pbPR.AttributeColumns[i] = col.Unparse()
-	}
 	return pbPR, nil
 }
 
-func (e *Encoder) EncodeDeleteRow(name string, condition *Condition, primaryKey map[string]interface{}) (proto.Message, error) {
+func (e *Encoder) EncodeDeleteRow(name string, condition *Condition, primaryKey PrimaryKey) (proto.Message, error) {
 	pbDRR := &protobuf.DeleteRowRequest{
 		TableName:  new(string),
 		Condition:  condition.Unparse(),
-		PrimaryKey: make([]*protobuf.Column, len(primaryKey)),
+		PrimaryKey: primaryKey.Unparse(),
 	}
 	*pbDRR.TableName = name
-	for i, pk := range ColumnsFromMap(primaryKey) {
-		pbDRR.PrimaryKey[i] = pk.Unparse()
-	}
 	return pbDRR, nil
 }
 
-func (e *Encoder) EncodeUpdateRow(name string, condition *Condition, primaryKey map[string]interface{}, columnsPut map[string]interface{}, columnsDelete []string) (proto.Message, error) {
+func (e *Encoder) EncodeUpdateRow(name string, condition *Condition, primaryKey PrimaryKey, columnsPut map[string]interface{}, columnsDelete []string) (proto.Message, error) {
 	pbURR := &protobuf.UpdateRowRequest{
 		TableName:        new(string),
 		Condition:        condition.Unparse(),
-		PrimaryKey:       make([]*protobuf.Column, len(primaryKey)),
-		AttributeColumns: make([]*protobuf.ColumnUpdate, len(columnsPut)+len(columnsDelete)),
+		PrimaryKey:       primaryKey.Unparse(),
+		AttributeColumns: unparseColumnUpdates(columnsPut, columnsDelete),
 	}
 	*pbURR.TableName = name
-	for i, pk := range ColumnsFromMap(primaryKey) {
-		pbURR.GetPrimaryKey()[i] = pk.Unparse()
+	return pbURR, nil
+}
+
+func unparseColumns(colMap map[string]interface{}) []*protobuf.Column {
+	pbCols := make([]*protobuf.Column, len(colMap))
+	for i, col := range ColumnsFromMap(colMap) {
+		pbCols[i] = col.Unparse()
 	}
+	return pbCols
+}
+
+func unparseColumnUpdates(columnsPut map[string]interface{}, columnsDelete []string) []*protobuf.ColumnUpdate {
+	pbCUs := make([]*protobuf.ColumnUpdate, len(columnsPut)+len(columnsDelete))
 	index := 0
 	for k, v := range columnsPut {
 		pbCU := &protobuf.ColumnUpdate{
@@ -131,7 +128,7 @@ func (e *Encoder) EncodeUpdateRow(name string, condition *Condition, primaryKey
 		}
 		*pbCU.Name = k
 		*pbCU.Type = protobuf.OperationType_PUT
-		pbURR.GetAttributeColumns()[index] = pbCU
+		pbCUs[index] = pbCU
 		index++
 	}
 	for _, n := range columnsDelete {
@@ -141,10 +138,10 @@ func (e *Encoder) EncodeUpdateRow(name string, condition *Condition, primaryKey
 		}
 		*pbCU.Name = n
 		*pbCU.Type = protobuf.OperationType_DELETE
-		pbURR.GetAttributeColumns()[index] = pbCU
+		pbCUs[index] = pbCU
 		index++
 	}
-	return pbURR, nil
+	return pbCUs
 }
 
 func (e *Encoder) EncodeBatchGetRow(items map[string]BatchGetRowItem) (proto.Message, error) {
@@ -152,8 +149,8 @@ func (e *Encoder) EncodeBatchGetRow(items map[string]BatchGetRowItem) (proto.Mes
 		Tables: make([]*protobuf.TableInBatchGetRowRequest, len(items)),
 	}
 
-	index := 0
-	for name, bgri := range items {
+	for index, name := range sortedTableNames(items) {
+		bgri := items[name]
 		pbTRR := &protobuf.TableInBatchGetRowRequest{
 			TableName:    new(string),
 			Rows:         make([]*protobuf.RowInBatchGetRowRequest, len(bgri.PrimaryKeys)),
@@ -161,14 +158,10 @@ func (e *Encoder) EncodeBatchGetRow(items map[string]BatchGetRowItem) (proto.Mes
 		}
 		*pbTRR.TableName = name
 
-		for i, pks := range bgri.PrimaryKeys {
-			pbRGRR := &protobuf.RowInBatchGetRowRequest{
-				PrimaryKey: make([]*protobuf.Column, len(pks)),
-			}
-			for j, pk := range ColumnsFromMap(pks) {
-				pbRGRR.GetPrimaryKey()[j] = pk.Unparse()
+		for i, pk := range bgri.PrimaryKeys {
+			pbTRR.GetRows()[i] = &protobuf.RowInBatchGetRowRequest{
+				PrimaryKey: pk.Unparse(),
 			}
-			pbTRR.GetRows()[i] = pbRGRR
 		}
 
 		for i, n := range bgri.ColumnNames {
@@ -176,8 +169,75 @@ func (e *Encoder) EncodeBatchGetRow(items map[string]BatchGetRowItem) (proto.Mes
 		}
 
 		pbBGRR.GetTables()[index] = pbTRR
-		index++
 	}
 
 	return pbBGRR, nil
 }
+
+// sortedWriteTableNames 返回按名称排序的表名，使编码结果与map的遍历顺序无关
+func sortedWriteTableNames(items map[string]BatchWriteRowItem) []string {
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *Encoder) EncodeBatchWriteRow(items map[string]BatchWriteRowItem) (proto.Message, error) {
+	pbBWRR := &protobuf.BatchWriteRowRequest{
+		Tables: make([]*protobuf.TableInBatchWriteRowRequest, len(items)),
+	}
+
+	for index, name := range sortedWriteTableNames(items) {
+		bwri := items[name]
+		pbTWRR := &protobuf.TableInBatchWriteRowRequest{
+			TableName:  new(string),
+			PutRows:    make([]*protobuf.PutRowInBatchWriteRowRequest, len(bwri.PutRows)),
+			UpdateRows: make([]*protobuf.UpdateRowInBatchWriteRowRequest, len(bwri.UpdateRows)),
+			DeleteRows: make([]*protobuf.DeleteRowInBatchWriteRowRequest, len(bwri.DeleteRows)),
+		}
+		*pbTWRR.TableName = name
+
+		for i, row := range bwri.PutRows {
+			pbTWRR.GetPutRows()[i] = &protobuf.PutRowInBatchWriteRowRequest{
+				Condition:        row.Condition.Unparse(),
+				PrimaryKey:       row.PrimaryKey.Unparse(),
+				AttributeColumns: unparseColumns(row.Columns),
+			}
+		}
+		for i, row := range bwri.UpdateRows {
+			pbTWRR.GetUpdateRows()[i] = &protobuf.UpdateRowInBatchWriteRowRequest{
+				Condition:        row.Condition.Unparse(),
+				PrimaryKey:       row.PrimaryKey.Unparse(),
+				AttributeColumns: unparseColumnUpdates(row.ColumnsPut, row.ColumnsDelete),
+			}
+		}
+		for i, row := range bwri.DeleteRows {
+			pbTWRR.GetDeleteRows()[i] = &protobuf.DeleteRowInBatchWriteRowRequest{
+				Condition:  row.Condition.Unparse(),
+				PrimaryKey: row.PrimaryKey.Unparse(),
+			}
+		}
+
+		pbBWRR.GetTables()[index] = pbTWRR
+	}
+
+	return pbBWRR, nil
+}
+
+func (e *Encoder) EncodeGetRange(req *GetRangeRequest) (proto.Message, error) {
+	pbGRR := &protobuf.GetRangeRequest{
+		TableName:                new(string),
+		Direction:                req.Direction.Unparse(),
+		ColumnsToGet:             req.ColumnNames,
+		InclusiveStartPrimaryKey: req.InclusiveStartPrimaryKey.Unparse(),
+		ExclusiveEndPrimaryKey:   req.ExclusiveEndPrimaryKey.Unparse(),
+	}
+	*pbGRR.TableName = req.TableName
+	if req.Limit > 0 {
+		pbGRR.Limit = new(int32)
+		*pbGRR.Limit = req.Limit
+	}
+	return pbGRR, nil
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestEncodeBatchWriteRowTableOrder(t *testing.T) {
+	items := make(map[string]BatchWriteRowItem)
+	for _, name := range []string{"users", "groups", "orders", "accounts", "items"} {
+		items[name] = BatchWriteRowItem{DeleteRows: []*DeleteRowInBatchWriteRowItem{
+			{Condition: &Condition{RowExistence: RowExistenceExpectationIgnore}, PrimaryKey: NewPrimaryKey().Add("id", 1)},
+		}}
+	}
+	e := &Encoder{}
+	var first []byte
+	for i := 0; i < 10; i++ {
+		message, err := e.EncodeBatchWriteRow(items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := proto.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = data
+			var names []string
+			for _, table := range message.(*protobuf.BatchWriteRowRequest).GetTables() {
+				names = append(names, table.GetTableName())
+			}
+			if got := fmt.Sprint(names); got != "[accounts groups items orders users]" {
+				t.Errorf("tables = %s, want sorted by name", got)
+			}
+		} else if !bytes.Equal(data, first) {
+			t.Fatal("EncodeBatchWriteRow() encoded the same items differently")
+		}
+	}
+}
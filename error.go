@@ -16,17 +16,100 @@
 
 package gots
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// OTS服务端返回的错误码
+const (
+	ErrorCodeAuthFailed                              = "OTSAuthFailed"
+	ErrorCodeRequestBodyTooLarge                     = "OTSRequestBodyTooLarge"
+	ErrorCodeRequestTimeout                          = "OTSRequestTimeout"
+	ErrorCodeMethodNotAllowed                        = "OTSMethodNotAllowed"
+	ErrorCodeParameterInvalid                        = "OTSParameterInvalid"
+	ErrorCodeQuotaExhausted                          = "OTSQuotaExhausted"
+	ErrorCodeInternalServerError                     = "OTSInternalServerError"
+	ErrorCodeServerBusy                              = "OTSServerBusy"
+	ErrorCodePartitionUnavailable                    = "OTSPartitionUnavailable"
+	ErrorCodeTimeout                                 = "OTSTimeout"
+	ErrorCodeServerUnavailable                       = "OTSServerUnavailable"
+	ErrorCodeRowOperationConflict                    = "OTSRowOperationConflict"
+	ErrorCodeObjectAlreadyExist                      = "OTSObjectAlreadyExist"
+	ErrorCodeObjectNotExist                          = "OTSObjectNotExist"
+	ErrorCodeTableNotReady                           = "OTSTableNotReady"
+	ErrorCodeTooFrequentReservedThroughputAdjustment = "OTSTooFrequentReservedThroughputAdjustment"
+	ErrorCodeNotEnoughCapacityUnit                   = "OTSNotEnoughCapacityUnit"
+	ErrorCodeConditionCheckFail                      = "OTSConditionCheckFail"
+	ErrorCodeOutOfRowSizeLimit                       = "OTSOutOfRowSizeLimit"
+	ErrorCodeOutOfColumnCountLimit                   = "OTSOutOfColumnCountLimit"
+	ErrorCodeInvalidPK                               = "OTSInvalidPK"
+)
+
+// 与错误码对应的错误，可用于errors.Is判断，只比较错误码。
+// 示例:
+//
+//	if errors.Is(err, gots.ErrObjectNotExist) {
+//		...
+//	}
+var (
+	ErrAuthFailed                              = &OTSServiceError{Code: ErrorCodeAuthFailed}
+	ErrRequestBodyTooLarge                     = &OTSServiceError{Code: ErrorCodeRequestBodyTooLarge}
+	ErrRequestTimeout                          = &OTSServiceError{Code: ErrorCodeRequestTimeout}
+	ErrMethodNotAllowed                        = &OTSServiceError{Code: ErrorCodeMethodNotAllowed}
+	ErrParameterInvalid                        = &OTSServiceError{Code: ErrorCodeParameterInvalid}
+	ErrQuotaExhausted                          = &OTSServiceError{Code: ErrorCodeQuotaExhausted}
+	ErrInternalServerError                     = &OTSServiceError{Code: ErrorCodeInternalServerError}
+	ErrServerBusy                              = &OTSServiceError{Code: ErrorCodeServerBusy}
+	ErrPartitionUnavailable                    = &OTSServiceError{Code: ErrorCodePartitionUnavailable}
+	ErrTimeout                                 = &OTSServiceError{Code: ErrorCodeTimeout}
+	ErrServerUnavailable                       = &OTSServiceError{Code: ErrorCodeServerUnavailable}
+	ErrRowOperationConflict                    = &OTSServiceError{Code: ErrorCodeRowOperationConflict}
+	ErrObjectAlreadyExist                      = &OTSServiceError{Code: ErrorCodeObjectAlreadyExist}
+	ErrObjectNotExist                          = &OTSServiceError{Code: ErrorCodeObjectNotExist}
+	ErrTableNotReady                           = &OTSServiceError{Code: ErrorCodeTableNotReady}
+	ErrTooFrequentReservedThroughputAdjustment = &OTSServiceError{Code: ErrorCodeTooFrequentReservedThroughputAdjustment}
+	ErrNotEnoughCapacityUnit                   = &OTSServiceError{Code: ErrorCodeNotEnoughCapacityUnit}
+	ErrConditionCheckFail                      = &OTSServiceError{Code: ErrorCodeConditionCheckFail}
+	ErrOutOfRowSizeLimit                       = &OTSServiceError{Code: ErrorCodeOutOfRowSizeLimit}
+	ErrOutOfColumnCountLimit                   = &OTSServiceError{Code: ErrorCodeOutOfColumnCountLimit}
+	ErrInvalidPK                               = &OTSServiceError{Code: ErrorCodeInvalidPK}
+)
+
+// throttlingErrorCodes 中的错误表示请求因流控没有被执行
+var throttlingErrorCodes = map[string]bool{
+	ErrorCodeServerBusy:            true,
+	ErrorCodeNotEnoughCapacityUnit: true,
+}
+
+// transientErrorCodes 中的错误是暂时性的，发生时请求可能已经被执行
+var transientErrorCodes = map[string]bool{
+	ErrorCodeTimeout:              true,
+	ErrorCodeInternalServerError:  true,
+	ErrorCodePartitionUnavailable: true,
+	ErrorCodeServerUnavailable:    true,
+}
 
 type OTSClientError struct {
 	Status  int
 	Message string
+	// Err 为发送请求或读取响应时网络层返回的原始错误
+	Err error
 }
 
 func (e *OTSClientError) Error() string {
 	return e.Message
 }
 
+func (e *OTSClientError) Unwrap() error {
+	return e.Err
+}
+
 type OTSServiceError struct {
 	Status    int
 	Code      string
@@ -37,3 +120,115 @@ type OTSServiceError struct {
 func (e *OTSServiceError) Error() string {
 	return fmt.Sprintf("ErrorCode: %s, ErrorMessage: %s, RequestID: %s", e.Code, e.Message, e.RequestID)
 }
+
+// Is 在target为错误码相同的OTSServiceError时返回true
+func (e *OTSServiceError) Is(target error) bool {
+	t, ok := target.(*OTSServiceError)
+	return ok && t.Code == e.Code
+}
+
+// ClockSkewError 表示响应中的x-ots-date与本地时间的差值超过了允许范围
+type ClockSkewError struct {
+	ServerTime time.Time
+	// LocalTime 为收到响应时校正后的本地时间
+	LocalTime time.Time
+	MaxSkew   time.Duration
+	// Compensated 为true时已记录新的时钟偏差，之后的请求会使用校正后的时间签名
+	Compensated bool
+	// Err 不为nil时表示服务端因时间差拒绝了请求，请求没有被执行
+	Err error
+}
+
+func (e *ClockSkewError) Error() string {
+	msg := fmt.Sprintf("The difference between date in response (%s) and local time (%s) is more than %v",
+		e.ServerTime.UTC().Format(TimeFormat), e.LocalTime.UTC().Format(TimeFormat), e.MaxSkew)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ClockSkewError) Unwrap() error {
+	return e.Err
+}
+
+// Skew 返回服务端时间减去本地时间
+func (e *ClockSkewError) Skew() time.Duration {
+	return e.ServerTime.Sub(e.LocalTime)
+}
+
+// IsClockSkew 返回err是否为本地时间与服务端时间差值过大导致的错误
+func IsClockSkew(err error) bool {
+	var skewErr *ClockSkewError
+	return errors.As(err, &skewErr)
+}
+
+// ErrorCode 返回err中的OTS错误码，err可以是OTSServiceError或批量操作中单行的Error
+func ErrorCode(err error) string {
+	var serviceErr *OTSServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Code
+	}
+	var rowErr *Error
+	if errors.As(err, &rowErr) {
+		return rowErr.Code
+	}
+	return ""
+}
+
+// IsThrottled 返回err是否为流控错误，此时请求没有被执行
+func IsThrottled(err error) bool {
+	return throttlingErrorCodes[ErrorCode(err)]
+}
+
+// IsRetryable 返回err是否为暂时性错误，包括流控、服务端超时或内部错误、5xx状态码以及网络错误。
+// 除流控和建立链接失败外，请求可能已经被执行，非幂等的操作重试前需要自行判断
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := ErrorCode(err)
+	if throttlingErrorCodes[code] || transientErrorCodes[code] {
+		return true
+	}
+	var serviceErr *OTSServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Status >= 500
+	}
+	var clientErr *OTSClientError
+	if errors.As(err, &clientErr) {
+		if clientErr.Err != nil {
+			return isTransportError(clientErr.Err)
+		}
+		return clientErr.Status >= 500
+	}
+	return false
+}
+
+// isTransportError 返回err是否为网络层的暂时性错误，context被取消或超时不算在内。
+// SocketTimeout导致的超时同样满足errors.Is(err, context.DeadlineExceeded)，
+// 因此调用方context的超时只按Client返回的ctx.Err()本身判断
+func isTransportError(err error) bool {
+	if errors.Is(err, context.Canceled) || err == context.DeadlineExceeded {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// IsConditionFailed 返回err是否为行存在性条件检查失败
+func IsConditionFailed(err error) bool {
+	return ErrorCode(err) == ErrorCodeConditionCheckFail
+}
+
+// IsNotFound 返回err是否为表不存在
+func IsNotFound(err error) bool {
+	return ErrorCode(err) == ErrorCodeObjectNotExist
+}
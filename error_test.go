@@ -0,0 +1,99 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &OTSServiceError{Status: 404, Code: ErrorCodeObjectNotExist, Message: "no table"})
+	if !errors.Is(err, ErrObjectNotExist) {
+		t.Errorf("errors.Is should match by code")
+	}
+	if errors.Is(err, ErrConditionCheckFail) {
+		t.Errorf("errors.Is should not match other codes")
+	}
+	if ErrorCode(err) != ErrorCodeObjectNotExist || !IsNotFound(err) {
+		t.Errorf("ErrorCode = %q", ErrorCode(err))
+	}
+	if !IsConditionFailed(&Error{Code: ErrorCodeConditionCheckFail}) {
+		t.Errorf("row error should be classified by code")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"throttled", &OTSServiceError{Status: 503, Code: ErrorCodeNotEnoughCapacityUnit}, true},
+		{"server busy", &OTSServiceError{Status: 503, Code: ErrorCodeServerBusy}, true},
+		{"internal", &OTSServiceError{Status: 500, Code: ErrorCodeInternalServerError}, true},
+		{"condition", &OTSServiceError{Status: 403, Code: ErrorCodeConditionCheckFail}, false},
+		{"not found", &OTSServiceError{Status: 404, Code: ErrorCodeObjectNotExist}, false},
+		{"unknown 5xx", &OTSServiceError{Status: 502, Code: "Unknown"}, true},
+		{"dial", &OTSClientError{Message: "send failed", Err: &url.Error{Op: "Post", URL: "http://x", Err: dialErr}}, true},
+		{"unexpected eof", &OTSClientError{Message: "read failed", Err: io.ErrUnexpectedEOF}, true},
+		{"canceled", &OTSClientError{Message: "send failed", Err: &url.Error{Op: "Post", URL: "http://x", Err: context.Canceled}}, false},
+		{"deadline", &OTSClientError{Message: "send failed", Err: context.DeadlineExceeded}, false},
+		{"credentials", &OTSClientError{Message: "Retrieve credentials failed", Err: errors.New("no profile")}, false},
+		{"unmarshal", &OTSClientError{Message: "Unmarshal response failed", Err: errors.New("proto: bad wire type")}, false},
+		{"client 5xx", &OTSClientError{Status: 503, Message: "HTTP status: 503"}, true},
+		{"client 4xx", &OTSClientError{Status: 400, Message: "HTTP status: 400"}, false},
+	}
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("%s: IsRetryable = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// blockingServer 返回在测试结束前不响应任何请求的服务
+func TestIsRetryableTimeouts(t *testing.T) {
+	ts := blockingServer(t)
+	c := NewClient(ts.URL, "id", "key", "instance")
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.ListTableWithContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || IsRetryable(err) {
+		t.Errorf("caller deadline: IsRetryable(%v) = %v, want false", err, IsRetryable(err))
+	}
+
+	c.SocketTimeout = 0.05
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ListTable()
+	if err == nil || !IsRetryable(err) {
+		t.Errorf("socket timeout: IsRetryable(%v) = false, want true", err)
+	}
+}
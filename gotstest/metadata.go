@@ -0,0 +1,94 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gotstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMetadataTTL 为MetadataServer签发的临时凭证的有效期
+const DefaultMetadataTTL = time.Hour
+
+// MetadataServer 模拟实例元数据服务中RAM角色临时凭证的接口，用于测试gots.MetadataCredentialsProvider。
+// 示例:
+//
+//	meta := gotstest.NewMetadataServer("role", "your_user_id", "your_user_key")
+//	ts := httptest.NewServer(meta)
+//	defer ts.Close()
+//
+//	client.Credentials = gots.NewMetadataCredentialsProvider(ts.URL + "/latest/meta-data/ram/security-credentials/")
+type MetadataServer struct {
+	RoleName  string
+	AccessID  string
+	AccessKey string
+	// TTL 为签发凭证的有效期，为0时使用DefaultMetadataTTL
+	TTL time.Duration
+
+	issued uint64
+}
+
+// NewMetadataServer 创建签发指定角色凭证的MetadataServer
+func NewMetadataServer(roleName, accessID, accessKey string) *MetadataServer {
+	return &MetadataServer{
+		RoleName:  roleName,
+		AccessID:  accessID,
+		AccessKey: accessKey,
+	}
+}
+
+// Issued 返回已签发的凭证数
+func (s *MetadataServer) Issued() int {
+	return int(atomic.LoadUint64(&s.issued))
+}
+
+// ServeHTTP 路径以/结尾时返回角色名，以角色名结尾时返回临时凭证
+func (s *MetadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		w.Write([]byte(s.RoleName))
+		return
+	}
+	if path.Base(r.URL.Path) != s.RoleName {
+		http.NotFound(w, r)
+		return
+	}
+
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = DefaultMetadataTTL
+	}
+	seq := atomic.AddUint64(&s.issued, 1)
+	creds := map[string]string{
+		"Code":            "Success",
+		"AccessKeyId":     s.AccessID,
+		"AccessKeySecret": s.AccessKey,
+		"SecurityToken":   fmt.Sprintf("token-%d", seq),
+		"Expiration":      time.Now().Add(ttl).UTC().Format(time.RFC3339),
+		"LastUpdated":     time.Now().UTC().Format(time.RFC3339),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creds)
+}
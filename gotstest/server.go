@@ -0,0 +1,207 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package gotstest 提供一个在内存中实现OTS协议的服务端，可以通过httptest启动后直接使用gots.Client访问，用于测试。
+// 示例:
+//
+//	srv := gotstest.NewServer("your_user_id", "your_user_key", "your_instance_name")
+//	ts := httptest.NewServer(srv)
+//	defer ts.Close()
+//
+//	client := gots.NewClient(ts.URL, "your_user_id", "your_user_key", "your_instance_name")
+//	client.Init()
+package gotstest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Xuyuanp/gots"
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// DefaultRangeLimit 为GetRange单次返回的默认最大行数
+const DefaultRangeLimit = 5000
+
+// Server 是实现了http.Handler的OTS服务端，数据保存在内存中
+type Server struct {
+	AccessID     string
+	AccessKey    string
+	InstanceName string
+	// RangeLimit 为GetRange单次返回的最大行数，超出时返回NextStartPrimaryKey
+	RangeLimit int
+	// ClockOffset 为服务端时钟相对本地时钟的偏差，用于模拟客户端时钟不准
+	ClockOffset time.Duration
+
+	mu        sync.Mutex
+	tables    map[string]*table
+	requestID uint64
+}
+
+// NewServer 返回一个没有任何表的Server
+func NewServer(accessID, accessKey, instanceName string) *Server {
+	return &Server{
+		AccessID:     accessID,
+		AccessKey:    accessKey,
+		InstanceName: instanceName,
+		RangeLimit:   DefaultRangeLimit,
+		tables:       make(map[string]*table),
+	}
+}
+
+type otsError struct {
+	status  int
+	code    string
+	message string
+}
+
+func errorf(status int, code string, format string, args ...interface{}) *otsError {
+	return &otsError{
+		status:  status,
+		code:    code,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apiName := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method != "POST" || !gots.AllowedAPI[apiName] {
+		s.writeError(w, apiName, errorf(http.StatusMethodNotAllowed, gots.ErrorCodeMethodNotAllowed, "%s /%s is not allowed", r.Method, apiName))
+		return
+	}
+	if err := s.protocol().VerifyRequest(r); err != nil {
+		var serviceErr *gots.OTSServiceError
+		if !errors.As(err, &serviceErr) {
+			serviceErr = &gots.OTSServiceError{Status: http.StatusForbidden, Code: gots.ErrorCodeAuthFailed, Message: err.Error()}
+		}
+		s.writeError(w, apiName, errorf(serviceErr.Status, serviceErr.Code, "%s", serviceErr.Message))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, apiName, errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Read request body failed: %s", err))
+		return
+	}
+
+	s.mu.Lock()
+	resp, oerr := s.handle(apiName, body)
+	s.mu.Unlock()
+	if oerr != nil {
+		s.writeError(w, apiName, oerr)
+		return
+	}
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		s.writeError(w, apiName, errorf(http.StatusInternalServerError, gots.ErrorCodeInternalServerError, "Marshal response failed: %s", err))
+		return
+	}
+	s.write(w, apiName, http.StatusOK, data)
+}
+
+func (s *Server) protocol() *gots.Protocol {
+	p := &gots.Protocol{
+		AccessID:     s.AccessID,
+		AccessKey:    s.AccessKey,
+		InstanceName: s.InstanceName,
+	}
+	if s.ClockOffset != 0 {
+		p.ClockSkewCompensation = true
+		p.SetClockOffset(s.ClockOffset)
+	}
+	return p
+}
+
+func (s *Server) handle(apiName string, body []byte) (proto.Message, *otsError) {
+	var req proto.Message
+	switch apiName {
+	case "CreateTable":
+		req = &protobuf.CreateTableRequest{}
+	case "ListTable":
+		req = &protobuf.ListTableRequest{}
+	case "DeleteTable":
+		req = &protobuf.DeleteTableRequest{}
+	case "DescribeTable":
+		req = &protobuf.DescribeTableRequest{}
+	case "UpdateTable":
+		req = &protobuf.UpdateTableRequest{}
+	case "GetRow":
+		req = &protobuf.GetRowRequest{}
+	case "PutRow":
+		req = &protobuf.PutRowRequest{}
+	case "UpdateRow":
+		req = &protobuf.UpdateRowRequest{}
+	case "DeleteRow":
+		req = &protobuf.DeleteRowRequest{}
+	case "BatchGetRow":
+		req = &protobuf.BatchGetRowRequest{}
+	case "BatchWriteRow":
+		req = &protobuf.BatchWriteRowRequest{}
+	case "GetRange":
+		req = &protobuf.GetRangeRequest{}
+	}
+	if err := proto.Unmarshal(body, req); err != nil {
+		return nil, errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Unmarshal request failed: %s", err)
+	}
+
+	switch req := req.(type) {
+	case *protobuf.CreateTableRequest:
+		return s.createTable(req)
+	case *protobuf.ListTableRequest:
+		return s.listTable(req)
+	case *protobuf.DeleteTableRequest:
+		return s.deleteTable(req)
+	case *protobuf.DescribeTableRequest:
+		return s.describeTable(req)
+	case *protobuf.UpdateTableRequest:
+		return s.updateTable(req)
+	case *protobuf.GetRowRequest:
+		return s.getRow(req)
+	case *protobuf.PutRowRequest:
+		return s.putRow(req)
+	case *protobuf.UpdateRowRequest:
+		return s.updateRow(req)
+	case *protobuf.DeleteRowRequest:
+		return s.deleteRow(req)
+	case *protobuf.BatchGetRowRequest:
+		return s.batchGetRow(req)
+	case *protobuf.BatchWriteRowRequest:
+		return s.batchWriteRow(req)
+	default:
+		return s.getRange(req.(*protobuf.GetRangeRequest))
+	}
+}
+
+func (s *Server) write(w http.ResponseWriter, apiName string, status int, data []byte) {
+	w.Header().Set(gots.HeaderOTSRequestID, fmt.Sprintf("%016x", atomic.AddUint64(&s.requestID, 1)))
+	s.protocol().SignResponse(apiName, w.Header(), data)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, apiName string, oerr *otsError) {
+	data, _ := proto.Marshal(&protobuf.Error{
+		Code:    proto.String(oerr.code),
+		Message: proto.String(oerr.message),
+	})
+	s.write(w, apiName, oerr.status, data)
+}
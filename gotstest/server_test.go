@@ -0,0 +1,187 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gotstest_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Xuyuanp/gots"
+	"github.com/Xuyuanp/gots/gotstest"
+)
+
+func newClient(t *testing.T, srv *gotstest.Server, accessKey string) *gots.Client {
+	t.Helper()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	client := gots.NewClient(ts.URL, srv.AccessID, accessKey, srv.InstanceName)
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func createTable(t *testing.T, client *gots.Client, name string) {
+	t.Helper()
+	pks := []*gots.ColumnSchema{
+		{Name: "gid", Type: gots.ColumnTypeInteger},
+		{Name: "uid", Type: gots.ColumnTypeInteger},
+	}
+	rt := &gots.ReservedThroughput{CapacityUnit: &gots.CapacityUnit{Read: 10, Write: 10}}
+	if _, err := client.CreateTable(name, pks, rt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func primaryKey(gid, uid int64) gots.PrimaryKey {
+	return gots.NewPrimaryKey().Add("gid", gid).Add("uid", uid)
+}
+
+func attribute(row *gots.Row, name string) interface{} {
+	for _, col := range row.AttributeColumns {
+		if col.Name == name {
+			return col.Value.Value()
+		}
+	}
+	return nil
+}
+
+func TestServerRowOperations(t *testing.T) {
+	srv := gotstest.NewServer("id", "key", "instance")
+	client := newClient(t, srv, "key")
+	createTable(t, client, "users")
+
+	ignore := &gots.Condition{RowExistence: gots.RowExistenceExpectationIgnore}
+	expectExist := &gots.Condition{RowExistence: gots.RowExistenceExpectationExpectExist}
+	expectNotExist := &gots.Condition{RowExistence: gots.RowExistenceExpectationExpectNotExist}
+
+	if _, err := client.PutRow("users", expectNotExist, primaryKey(1, 1), map[string]interface{}{"name": "a", "age": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PutRow("users", expectNotExist, primaryKey(1, 1), nil); !gots.IsConditionFailed(err) {
+		t.Fatalf("PutRow(existing, EXPECT_NOT_EXIST) = %v, want condition failure", err)
+	}
+	if _, err := client.UpdateRow("users", expectExist, primaryKey(1, 1), map[string]interface{}{"age": int64(2)}, []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.GetRow("users", primaryKey(1, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attribute(resp.Row, "age"); got != int64(2) {
+		t.Errorf("age = %v, want 2", got)
+	}
+	if got := attribute(resp.Row, "name"); got != nil {
+		t.Errorf("name = %v, want deleted", got)
+	}
+	if resp.Consumed.CapacityUnit.Read != 1 {
+		t.Errorf("consumed read = %d, want 1", resp.Consumed.CapacityUnit.Read)
+	}
+
+	if _, err := client.DeleteRow("users", ignore, primaryKey(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.GetRow("users", primaryKey(1, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Row.PrimaryKeyColumns) != 0 {
+		t.Errorf("row still exists after DeleteRow: %v", resp.Row)
+	}
+
+	if _, err := client.GetRow("missing", primaryKey(1, 1), nil); !gots.IsNotFound(err) {
+		t.Errorf("GetRow(missing table) = %v, want not found", err)
+	}
+}
+
+func TestServerGetRangePaging(t *testing.T) {
+	srv := gotstest.NewServer("id", "key", "instance")
+	srv.RangeLimit = 2
+	client := newClient(t, srv, "key")
+	createTable(t, client, "users")
+
+	ignore := &gots.Condition{RowExistence: gots.RowExistenceExpectationIgnore}
+	for uid := int64(5); uid > 0; uid-- {
+		if _, err := client.PutRow("users", ignore, primaryKey(1, uid), map[string]interface{}{"n": uid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := &gots.GetRangeRequest{
+		TableName:                "users",
+		InclusiveStartPrimaryKey: gots.NewPrimaryKey().Add("gid", gots.INFMin).Add("uid", gots.INFMin),
+		ExclusiveEndPrimaryKey:   gots.NewPrimaryKey().Add("gid", gots.INFMax).Add("uid", gots.INFMax),
+	}
+	resp, err := client.GetRange(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Rows) != 2 || resp.NextStartPrimaryKey == nil {
+		t.Fatalf("first page = %d rows, next %v, want 2 rows and a next key", len(resp.Rows), resp.NextStartPrimaryKey)
+	}
+	if uid := resp.NextStartPrimaryKey.Get("uid").Value(); uid != int64(3) {
+		t.Errorf("next start uid = %v, want 3", uid)
+	}
+
+	it := client.XGetRange(req, nil)
+	var uids []int64
+	for it.Next() {
+		uids = append(uids, it.Row().PrimaryKey().Get("uid").VInt)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for i, uid := range uids {
+		if uid != int64(i+1) {
+			t.Fatalf("XGetRange uids = %v, want [1 2 3 4 5]", uids)
+		}
+	}
+	if len(uids) != 5 {
+		t.Fatalf("XGetRange uids = %v, want [1 2 3 4 5]", uids)
+	}
+
+	req.Direction = gots.DirectionBackward
+	req.InclusiveStartPrimaryKey, req.ExclusiveEndPrimaryKey = req.ExclusiveEndPrimaryKey, req.InclusiveStartPrimaryKey
+	req.Limit = 3
+	it = client.XGetRange(req, nil)
+	uids = uids[:0]
+	for it.Next() {
+		uids = append(uids, it.Row().PrimaryKey().Get("uid").VInt)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 3 || uids[0] != 5 || uids[2] != 3 {
+		t.Errorf("backward XGetRange uids = %v, want [5 4 3]", uids)
+	}
+}
+
+func TestServerRejectsInvalidSignature(t *testing.T) {
+	srv := gotstest.NewServer("id", "key", "instance")
+	client := newClient(t, srv, "wrong key")
+
+	_, err := client.ListTable()
+	var serviceErr *gots.OTSServiceError
+	if !errors.As(err, &serviceErr) {
+		t.Fatalf("ListTable() = %v, want *OTSServiceError", err)
+	}
+	if serviceErr.Status != 403 || serviceErr.Code != gots.ErrorCodeAuthFailed {
+		t.Errorf("ListTable() = %v, want 403 %s", serviceErr, gots.ErrorCodeAuthFailed)
+	}
+}
@@ -0,0 +1,606 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gotstest
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Xuyuanp/gots"
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// capacityUnitSize 为一个读写吞吐量单位对应的数据大小
+const capacityUnitSize = 4096
+
+type row struct {
+	primaryKey []*protobuf.Column
+	attributes map[string]*protobuf.ColumnValue
+}
+
+func (r *row) size() int {
+	size := 0
+	for _, col := range r.primaryKey {
+		size += proto.Size(col)
+	}
+	for name, value := range r.attributes {
+		size += len(name) + proto.Size(value)
+	}
+	return size
+}
+
+// unparse 返回只包含columnsToGet中的列的行，columnsToGet为空时返回所有列
+func (r *row) unparse(columnsToGet []string) *protobuf.Row {
+	wanted := make(map[string]bool, len(columnsToGet))
+	for _, name := range columnsToGet {
+		wanted[name] = true
+	}
+	pbRow := &protobuf.Row{}
+	for _, col := range r.primaryKey {
+		if len(wanted) == 0 || wanted[col.GetName()] {
+			pbRow.PrimaryKeyColumns = append(pbRow.PrimaryKeyColumns, col)
+		}
+	}
+	names := make([]string, 0, len(r.attributes))
+	for name := range r.attributes {
+		if len(wanted) == 0 || wanted[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pbRow.AttributeColumns = append(pbRow.AttributeColumns, &protobuf.Column{
+			Name:  proto.String(name),
+			Value: r.attributes[name],
+		})
+	}
+	return pbRow
+}
+
+type table struct {
+	meta            *protobuf.TableMeta
+	capacityUnit    *protobuf.CapacityUnit
+	lastIncrease    int64
+	lastDecrease    int64
+	decreasesToday  int32
+	lastDecreaseDay string
+	// rows 按主键升序排列
+	rows []*row
+}
+
+func (t *table) details() *protobuf.ReservedThroughputDetails {
+	return &protobuf.ReservedThroughputDetails{
+		CapacityUnit:           t.capacityUnit,
+		LastIncreaseTime:       proto.Int64(t.lastIncrease),
+		LastDecreaseTime:       proto.Int64(t.lastDecrease),
+		NumberOfDecreasesToday: proto.Int32(t.decreasesToday),
+	}
+}
+
+func valueRank(t protobuf.ColumnType) int {
+	switch t {
+	case protobuf.ColumnType_INF_MIN:
+		return -1
+	case protobuf.ColumnType_INF_MAX:
+		return 1
+	}
+	return 0
+}
+
+func compareValue(a, b *protobuf.ColumnValue) int {
+	ra, rb := valueRank(a.GetType()), valueRank(b.GetType())
+	if ra != rb {
+		return ra - rb
+	}
+	if ra != 0 {
+		return 0
+	}
+	if a.GetType() != b.GetType() {
+		return int(a.GetType()) - int(b.GetType())
+	}
+	switch a.GetType() {
+	case protobuf.ColumnType_INTEGER:
+		switch {
+		case a.GetVInt() < b.GetVInt():
+			return -1
+		case a.GetVInt() > b.GetVInt():
+			return 1
+		}
+	case protobuf.ColumnType_STRING:
+		return strings.Compare(a.GetVString(), b.GetVString())
+	case protobuf.ColumnType_BINARY:
+		return bytes.Compare(a.GetVBinary(), b.GetVBinary())
+	case protobuf.ColumnType_DOUBLE:
+		switch {
+		case a.GetVDouble() < b.GetVDouble():
+			return -1
+		case a.GetVDouble() > b.GetVDouble():
+			return 1
+		}
+	case protobuf.ColumnType_BOOLEAN:
+		if a.GetVBool() != b.GetVBool() {
+			if b.GetVBool() {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func comparePrimaryKey(a, b []*protobuf.Column) int {
+	for i := range a {
+		if c := compareValue(a[i].GetValue(), b[i].GetValue()); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// search 返回第一个主键不小于pk的行的下标，以及该行的主键是否与pk相同
+func (t *table) search(pk []*protobuf.Column) (int, bool) {
+	i := sort.Search(len(t.rows), func(i int) bool {
+		return comparePrimaryKey(t.rows[i].primaryKey, pk) >= 0
+	})
+	return i, i < len(t.rows) && comparePrimaryKey(t.rows[i].primaryKey, pk) == 0
+}
+
+func (t *table) get(pk []*protobuf.Column) *row {
+	if i, ok := t.search(pk); ok {
+		return t.rows[i]
+	}
+	return nil
+}
+
+func (t *table) put(r *row) {
+	i, ok := t.search(r.primaryKey)
+	if ok {
+		t.rows[i] = r
+		return
+	}
+	t.rows = append(t.rows, nil)
+	copy(t.rows[i+1:], t.rows[i:])
+	t.rows[i] = r
+}
+
+func (t *table) delete(pk []*protobuf.Column) {
+	if i, ok := t.search(pk); ok {
+		t.rows = append(t.rows[:i], t.rows[i+1:]...)
+	}
+}
+
+func (t *table) checkPrimaryKey(pk []*protobuf.Column, allowINF bool) *otsError {
+	schema := t.meta.GetPrimaryKey()
+	if len(pk) != len(schema) {
+		return errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Table %s has %d primary key columns, %d given", t.meta.GetTableName(), len(schema), len(pk))
+	}
+	for i, cs := range schema {
+		col := pk[i]
+		if col.GetName() != cs.GetName() {
+			return errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Primary key column %d of table %s is %s, %s given", i, t.meta.GetTableName(), cs.GetName(), col.GetName())
+		}
+		ct := col.GetValue().GetType()
+		if allowINF && valueRank(ct) != 0 {
+			continue
+		}
+		if ct != cs.GetType() {
+			return errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Type of primary key column %s of table %s is %s, %s given", cs.GetName(), t.meta.GetTableName(), cs.GetType(), ct)
+		}
+	}
+	return nil
+}
+
+func (t *table) checkAttribute(name string, value *protobuf.ColumnValue) *otsError {
+	for _, cs := range t.meta.GetPrimaryKey() {
+		if cs.GetName() == name {
+			return errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Attribute column %s conflicts with primary key of table %s", name, t.meta.GetTableName())
+		}
+	}
+	if value != nil && valueRank(value.GetType()) != 0 {
+		return errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "%s is not allowed in attribute column %s", value.GetType(), name)
+	}
+	return nil
+}
+
+func checkCondition(condition *protobuf.Condition, exists bool) *otsError {
+	switch condition.GetRowExistence() {
+	case protobuf.RowExistenceExpectation_EXPECT_EXIST:
+		if !exists {
+			return errorf(http.StatusForbidden, gots.ErrorCodeConditionCheckFail, "Condition check failed")
+		}
+	case protobuf.RowExistenceExpectation_EXPECT_NOT_EXIST:
+		if exists {
+			return errorf(http.StatusForbidden, gots.ErrorCodeConditionCheckFail, "Condition check failed")
+		}
+	}
+	return nil
+}
+
+func consumed(read, write int) *protobuf.ConsumedCapacity {
+	cu := func(size int) int32 {
+		if size <= 0 {
+			return 0
+		}
+		return int32((size + capacityUnitSize - 1) / capacityUnitSize)
+	}
+	return &protobuf.ConsumedCapacity{
+		CapacityUnit: &protobuf.CapacityUnit{
+			Read:  proto.Int32(cu(read)),
+			Write: proto.Int32(cu(write)),
+		},
+	}
+}
+
+func (s *Server) table(name string) (*table, *otsError) {
+	t, ok := s.tables[name]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, gots.ErrorCodeObjectNotExist, "Requested table %s does not exist", name)
+	}
+	return t, nil
+}
+
+func (s *Server) createTable(req *protobuf.CreateTableRequest) (proto.Message, *otsError) {
+	name := req.GetTableMeta().GetTableName()
+	if _, ok := s.tables[name]; ok {
+		return nil, errorf(http.StatusConflict, gots.ErrorCodeObjectAlreadyExist, "Requested table %s already exists", name)
+	}
+	if len(req.GetTableMeta().GetPrimaryKey()) == 0 {
+		return nil, errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Table %s has no primary key", name)
+	}
+	s.tables[name] = &table{
+		meta:         req.GetTableMeta(),
+		capacityUnit: req.GetReservedThroughput().GetCapacityUnit(),
+		lastIncrease: time.Now().Unix(),
+	}
+	return &protobuf.CreateTableResponse{}, nil
+}
+
+func (s *Server) listTable(req *protobuf.ListTableRequest) (proto.Message, *otsError) {
+	resp := &protobuf.ListTableResponse{}
+	for name := range s.tables {
+		resp.TableNames = append(resp.TableNames, name)
+	}
+	sort.Strings(resp.TableNames)
+	return resp, nil
+}
+
+func (s *Server) deleteTable(req *protobuf.DeleteTableRequest) (proto.Message, *otsError) {
+	if _, err := s.table(req.GetTableName()); err != nil {
+		return nil, err
+	}
+	delete(s.tables, req.GetTableName())
+	return &protobuf.DeleteTableResponse{}, nil
+}
+
+func (s *Server) describeTable(req *protobuf.DescribeTableRequest) (proto.Message, *otsError) {
+	t, err := s.table(req.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	return &protobuf.DescribeTableResponse{
+		TableMeta:                 t.meta,
+		ReservedThroughputDetails: t.details(),
+	}, nil
+}
+
+func (s *Server) updateTable(req *protobuf.UpdateTableRequest) (proto.Message, *otsError) {
+	t, err := s.table(req.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	old := t.capacityUnit
+	cu := req.GetReservedThroughput().GetCapacityUnit()
+	updated := &protobuf.CapacityUnit{
+		Read:  proto.Int32(old.GetRead()),
+		Write: proto.Int32(old.GetWrite()),
+	}
+	if cu.Read != nil {
+		updated.Read = proto.Int32(cu.GetRead())
+	}
+	if cu.Write != nil {
+		updated.Write = proto.Int32(cu.GetWrite())
+	}
+	if updated.GetRead() > old.GetRead() || updated.GetWrite() > old.GetWrite() {
+		t.lastIncrease = now.Unix()
+	}
+	if updated.GetRead() < old.GetRead() || updated.GetWrite() < old.GetWrite() {
+		day := now.UTC().Format("2006-01-02")
+		if day != t.lastDecreaseDay {
+			t.lastDecreaseDay = day
+			t.decreasesToday = 0
+		}
+		t.decreasesToday++
+		t.lastDecrease = now.Unix()
+	}
+	t.capacityUnit = updated
+	return &protobuf.UpdateTableResponse{
+		ReservedThroughputDetails: t.details(),
+	}, nil
+}
+
+func (s *Server) getRow(req *protobuf.GetRowRequest) (proto.Message, *otsError) {
+	t, err := s.table(req.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	pbRow, size, err := t.getRow(req.GetPrimaryKey(), req.GetColumnsToGet())
+	if err != nil {
+		return nil, err
+	}
+	return &protobuf.GetRowResponse{
+		Consumed: consumed(size, 0),
+		Row:      pbRow,
+	}, nil
+}
+
+func (t *table) getRow(pk []*protobuf.Column, columnsToGet []string) (*protobuf.Row, int, *otsError) {
+	if err := t.checkPrimaryKey(pk, false); err != nil {
+		return nil, 0, err
+	}
+	r := t.get(pk)
+	if r == nil {
+		return &protobuf.Row{}, 1, nil
+	}
+	return r.unparse(columnsToGet), r.size(), nil
+}
+
+func (s *Server) putRow(req *protobuf.PutRowRequest) (proto.Message, *otsError) {
+	t, err := s.table(req.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	size, err := t.putRow(req.GetCondition(), req.GetPrimaryKey(), req.GetAttributeColumns())
+	if err != nil {
+		return nil, err
+	}
+	return &protobuf.PutRowResponse{Consumed: consumed(0, size)}, nil
+}
+
+func (t *table) putRow(condition *protobuf.Condition, pk []*protobuf.Column, columns []*protobuf.Column) (int, *otsError) {
+	if err := t.checkPrimaryKey(pk, false); err != nil {
+		return 0, err
+	}
+	if err := checkCondition(condition, t.get(pk) != nil); err != nil {
+		return 0, err
+	}
+	r := &row{
+		primaryKey: pk,
+		attributes: make(map[string]*protobuf.ColumnValue, len(columns)),
+	}
+	for _, col := range columns {
+		if err := t.checkAttribute(col.GetName(), col.GetValue()); err != nil {
+			return 0, err
+		}
+		r.attributes[col.GetName()] = col.GetValue()
+	}
+	t.put(r)
+	return r.size(), nil
+}
+
+func (s *Server) updateRow(req *protobuf.UpdateRowRequest) (proto.Message, *otsError) {
+	t, err := s.table(req.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	size, err := t.updateRow(req.GetCondition(), req.GetPrimaryKey(), req.GetAttributeColumns())
+	if err != nil {
+		return nil, err
+	}
+	return &protobuf.UpdateRowResponse{Consumed: consumed(0, size)}, nil
+}
+
+func (t *table) updateRow(condition *protobuf.Condition, pk []*protobuf.Column, updates []*protobuf.ColumnUpdate) (int, *otsError) {
+	if err := t.checkPrimaryKey(pk, false); err != nil {
+		return 0, err
+	}
+	old := t.get(pk)
+	if err := checkCondition(condition, old != nil); err != nil {
+		return 0, err
+	}
+	r := &row{
+		primaryKey: pk,
+		attributes: make(map[string]*protobuf.ColumnValue),
+	}
+	if old != nil {
+		for name, value := range old.attributes {
+			r.attributes[name] = value
+		}
+	}
+	for _, cu := range updates {
+		if err := t.checkAttribute(cu.GetName(), cu.GetValue()); err != nil {
+			return 0, err
+		}
+		if cu.GetType() == protobuf.OperationType_DELETE {
+			delete(r.attributes, cu.GetName())
+			continue
+		}
+		if cu.GetValue() == nil {
+			return 0, errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Value of column %s is missing", cu.GetName())
+		}
+		r.attributes[cu.GetName()] = cu.GetValue()
+	}
+	t.put(r)
+	return r.size(), nil
+}
+
+func (s *Server) deleteRow(req *protobuf.DeleteRowRequest) (proto.Message, *otsError) {
+	t, err := s.table(req.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	size, err := t.deleteRow(req.GetCondition(), req.GetPrimaryKey())
+	if err != nil {
+		return nil, err
+	}
+	return &protobuf.DeleteRowResponse{Consumed: consumed(0, size)}, nil
+}
+
+func (t *table) deleteRow(condition *protobuf.Condition, pk []*protobuf.Column) (int, *otsError) {
+	if err := t.checkPrimaryKey(pk, false); err != nil {
+		return 0, err
+	}
+	old := t.get(pk)
+	if err := checkCondition(condition, old != nil); err != nil {
+		return 0, err
+	}
+	if old == nil {
+		return 1, nil
+	}
+	t.delete(pk)
+	return old.size(), nil
+}
+
+func rowError(err *otsError) *protobuf.Error {
+	return &protobuf.Error{
+		Code:    proto.String(err.code),
+		Message: proto.String(err.message),
+	}
+}
+
+func (s *Server) batchGetRow(req *protobuf.BatchGetRowRequest) (proto.Message, *otsError) {
+	resp := &protobuf.BatchGetRowResponse{}
+	for _, tr := range req.GetTables() {
+		ttr := &protobuf.TableInBatchGetRowResponse{TableName: proto.String(tr.GetTableName())}
+		t, terr := s.table(tr.GetTableName())
+		for _, rr := range tr.GetRows() {
+			rrr := &protobuf.RowInBatchGetRowResponse{IsOk: proto.Bool(true)}
+			err := terr
+			if err == nil {
+				var pbRow *protobuf.Row
+				var size int
+				pbRow, size, err = t.getRow(rr.GetPrimaryKey(), tr.GetColumnsToGet())
+				if err == nil {
+					rrr.Row = pbRow
+					rrr.Consumed = consumed(size, 0)
+				}
+			}
+			if err != nil {
+				rrr.IsOk = proto.Bool(false)
+				rrr.Error = rowError(err)
+			}
+			ttr.Rows = append(ttr.Rows, rrr)
+		}
+		resp.Tables = append(resp.Tables, ttr)
+	}
+	return resp, nil
+}
+
+func writeRowResponse(size int, err *otsError) *protobuf.RowInBatchWriteRowResponse {
+	if err != nil {
+		return &protobuf.RowInBatchWriteRowResponse{
+			IsOk:  proto.Bool(false),
+			Error: rowError(err),
+		}
+	}
+	return &protobuf.RowInBatchWriteRowResponse{
+		IsOk:     proto.Bool(true),
+		Consumed: consumed(0, size),
+	}
+}
+
+func (s *Server) batchWriteRow(req *protobuf.BatchWriteRowRequest) (proto.Message, *otsError) {
+	resp := &protobuf.BatchWriteRowResponse{}
+	for _, tr := range req.GetTables() {
+		twr := &protobuf.TableInBatchWriteRowResponse{TableName: proto.String(tr.GetTableName())}
+		t, terr := s.table(tr.GetTableName())
+		for _, r := range tr.GetPutRows() {
+			size, err := 0, terr
+			if err == nil {
+				size, err = t.putRow(r.GetCondition(), r.GetPrimaryKey(), r.GetAttributeColumns())
+			}
+			twr.PutRows = append(twr.PutRows, writeRowResponse(size, err))
+		}
+		for _, r := range tr.GetUpdateRows() {
+			size, err := 0, terr
+			if err == nil {
+				size, err = t.updateRow(r.GetCondition(), r.GetPrimaryKey(), r.GetAttributeColumns())
+			}
+			twr.UpdateRows = append(twr.UpdateRows, writeRowResponse(size, err))
+		}
+		for _, r := range tr.GetDeleteRows() {
+			size, err := 0, terr
+			if err == nil {
+				size, err = t.deleteRow(r.GetCondition(), r.GetPrimaryKey())
+			}
+			twr.DeleteRows = append(twr.DeleteRows, writeRowResponse(size, err))
+		}
+		resp.Tables = append(resp.Tables, twr)
+	}
+	return resp, nil
+}
+
+func (s *Server) getRange(req *protobuf.GetRangeRequest) (proto.Message, *otsError) {
+	t, err := s.table(req.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	start, end := req.GetInclusiveStartPrimaryKey(), req.GetExclusiveEndPrimaryKey()
+	if err := t.checkPrimaryKey(start, true); err != nil {
+		return nil, err
+	}
+	if err := t.checkPrimaryKey(end, true); err != nil {
+		return nil, err
+	}
+	limit := s.RangeLimit
+	if limit <= 0 {
+		limit = DefaultRangeLimit
+	}
+	if req.Limit != nil {
+		if req.GetLimit() <= 0 {
+			return nil, errorf(http.StatusBadRequest, gots.ErrorCodeParameterInvalid, "Limit must be positive")
+		}
+		if int(req.GetLimit()) < limit {
+			limit = int(req.GetLimit())
+		}
+	}
+
+	resp := &protobuf.GetRangeResponse{}
+	size := 0
+	forward := req.GetDirection() == protobuf.Direction_FORWARD
+	i, found := t.search(start)
+	if !forward && !found {
+		i--
+	}
+	for i >= 0 && i < len(t.rows) {
+		r := t.rows[i]
+		c := comparePrimaryKey(r.primaryKey, end)
+		if (forward && c >= 0) || (!forward && c <= 0) {
+			break
+		}
+		if len(resp.Rows) == limit {
+			resp.NextStartPrimaryKey = r.primaryKey
+			break
+		}
+		resp.Rows = append(resp.Rows, r.unparse(req.GetColumnsToGet()))
+		size += r.size()
+		if forward {
+			i++
+		} else {
+			i--
+		}
+	}
+	if size == 0 {
+		size = 1
+	}
+	resp.Consumed = consumed(size, 0)
+	return resp, nil
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// Invocation 描述一次发往OTS的请求，重试时每次尝试都是一个新的Invocation
+type Invocation struct {
+	// APIName 为OTS API名，如PutRow
+	APIName string
+	// Request 为请求的protocol buffer消息，拦截器不应修改
+	Request proto.Message
+	// HTTPRequest 为已签名的HTTP请求，拦截器可以添加非x-ots-前缀的头，修改x-ots-头会导致签名失效
+	HTTPRequest *http.Request
+	// Attempt 为当前尝试次数，从1开始
+	Attempt int
+	// Start 为本次尝试开始的时间
+	Start time.Time
+
+	// 以下字段在请求发送后填充
+	// Duration 为发送请求到解析完响应的耗时
+	Duration time.Duration
+	// StatusCode 为HTTP响应码，请求未发出时为0
+	StatusCode int
+	// RequestID 为服务端返回的x-ots-requestid
+	RequestID string
+	// ResponseBody 为响应的原始内容
+	ResponseBody []byte
+	// Response 为解码后的响应消息，仅在请求成功且配置了拦截器、Tracer、限流器、Autoscaler或记录请求内容时填充
+	Response proto.Message
+}
+
+// TableNames 返回请求涉及的表名，按表名排序，ListTable返回nil
+func (inv *Invocation) TableNames() []string {
+	return requestTableNames(inv.Request)
+}
+
+// RowCount 返回本次请求涉及的行数，GetRange为返回的行数，其他API为请求中的行数，表操作为0
+func (inv *Invocation) RowCount() int {
+	count := 0
+	switch m := inv.Request.(type) {
+	case *protobuf.GetRowRequest, *protobuf.PutRowRequest, *protobuf.UpdateRowRequest, *protobuf.DeleteRowRequest:
+		count = 1
+	case *protobuf.BatchGetRowRequest:
+		for _, table := range m.GetTables() {
+			count += len(table.GetRows())
+		}
+	case *protobuf.BatchWriteRowRequest:
+		for _, table := range m.GetTables() {
+			count += len(table.GetPutRows()) + len(table.GetUpdateRows()) + len(table.GetDeleteRows())
+		}
+	case *protobuf.GetRangeRequest:
+		if resp, ok := inv.Response.(*protobuf.GetRangeResponse); ok {
+			count = len(resp.GetRows())
+		}
+	}
+	return count
+}
+
+// requestTableNames 返回请求消息涉及的表名，按表名排序
+func requestTableNames(message proto.Message) []string {
+	var names []string
+	switch m := message.(type) {
+	case *protobuf.CreateTableRequest:
+		names = []string{m.GetTableMeta().GetTableName()}
+	case *protobuf.DeleteTableRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.DescribeTableRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.UpdateTableRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.GetRowRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.PutRowRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.UpdateRowRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.DeleteRowRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.GetRangeRequest:
+		names = []string{m.GetTableName()}
+	case *protobuf.BatchGetRowRequest:
+		for _, table := range m.GetTables() {
+			names = append(names, table.GetTableName())
+		}
+	case *protobuf.BatchWriteRowRequest:
+		for _, table := range m.GetTables() {
+			names = append(names, table.GetTableName())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConsumedCapacity 返回响应中各表消耗的读写能力单元之和，请求失败时返回nil
+func (inv *Invocation) ConsumedCapacity() map[string]*CapacityUnit {
+	if inv.Response == nil {
+		return nil
+	}
+	consumed := make(map[string]*CapacityUnit)
+	add := func(tableName string, c *protobuf.ConsumedCapacity) {
+		if c == nil {
+			return
+		}
+		cu, ok := consumed[tableName]
+		if !ok {
+			cu = &CapacityUnit{}
+			consumed[tableName] = cu
+		}
+		cu.Read += c.GetCapacityUnit().GetRead()
+		cu.Write += c.GetCapacityUnit().GetWrite()
+	}
+	tableName := func() string {
+		if names := inv.TableNames(); len(names) == 1 {
+			return names[0]
+		}
+		return ""
+	}
+	switch m := inv.Response.(type) {
+	case *protobuf.GetRowResponse:
+		add(tableName(), m.GetConsumed())
+	case *protobuf.PutRowResponse:
+		add(tableName(), m.GetConsumed())
+	case *protobuf.UpdateRowResponse:
+		add(tableName(), m.GetConsumed())
+	case *protobuf.DeleteRowResponse:
+		add(tableName(), m.GetConsumed())
+	case *protobuf.GetRangeResponse:
+		add(tableName(), m.GetConsumed())
+	case *protobuf.BatchGetRowResponse:
+		for _, table := range m.GetTables() {
+			for _, row := range table.GetRows() {
+				add(table.GetTableName(), row.GetConsumed())
+			}
+		}
+	case *protobuf.BatchWriteRowResponse:
+		for _, table := range m.GetTables() {
+			for _, rows := range [][]*protobuf.RowInBatchWriteRowResponse{table.GetPutRows(), table.GetUpdateRows(), table.GetDeleteRows()} {
+				for _, row := range rows {
+					add(table.GetTableName(), row.GetConsumed())
+				}
+			}
+		}
+	}
+	return consumed
+}
+
+// Handler 发送Invocation描述的请求
+type Handler func(ctx context.Context, inv *Invocation) error
+
+// Interceptor 包装一次请求的发送过程，next为下一个拦截器或实际发送请求的Handler。
+// 拦截器可以在调用next前后执行逻辑，也可以不调用next直接返回错误。
+// 示例:
+//
+//	client.Interceptors = append(client.Interceptors, func(ctx context.Context, inv *gots.Invocation, next gots.Handler) error {
+//		inv.HTTPRequest.Header.Set("X-Caller", "my-service")
+//		err := next(ctx, inv)
+//		log.Printf("%s %s %v %v", inv.APIName, inv.RequestID, inv.Duration, err)
+//		return err
+//	})
+type Interceptor func(ctx context.Context, inv *Invocation, next Handler) error
+
+// ChainInterceptors 将多个拦截器按顺序组合为一个，第一个拦截器在最外层
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	switch len(interceptors) {
+	case 0:
+		return func(ctx context.Context, inv *Invocation, next Handler) error {
+			return next(ctx, inv)
+		}
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, inv *Invocation, next Handler) error {
+		return chainHandler(interceptors, next)(ctx, inv)
+	}
+}
+
+// chainHandler 返回依次经过interceptors后调用handler的Handler
+func chainHandler(interceptors []Interceptor, handler Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, inv *Invocation) error {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return handler
+}
+
+// responseMessages 为各API响应消息的构造函数
+var responseMessages = map[string]func() proto.Message{
+	"ListTable":     func() proto.Message { return &protobuf.ListTableResponse{} },
+	"CreateTable":   func() proto.Message { return &protobuf.CreateTableResponse{} },
+	"DeleteTable":   func() proto.Message { return &protobuf.DeleteTableResponse{} },
+	"DescribeTable": func() proto.Message { return &protobuf.DescribeTableResponse{} },
+	"UpdateTable":   func() proto.Message { return &protobuf.UpdateTableResponse{} },
+	"GetRow":        func() proto.Message { return &protobuf.GetRowResponse{} },
+	"PutRow":        func() proto.Message { return &protobuf.PutRowResponse{} },
+	"UpdateRow":     func() proto.Message { return &protobuf.UpdateRowResponse{} },
+	"DeleteRow":     func() proto.Message { return &protobuf.DeleteRowResponse{} },
+	"BatchGetRow":   func() proto.Message { return &protobuf.BatchGetRowResponse{} },
+	"BatchWriteRow": func() proto.Message { return &protobuf.BatchWriteRowResponse{} },
+	"GetRange":      func() proto.Message { return &protobuf.GetRangeResponse{} },
+}
+
+// decodeResponse 将响应内容解码为apiName对应的响应消息
+func decodeResponse(apiName string, data []byte) (proto.Message, error) {
+	newMessage, ok := responseMessages[apiName]
+	if !ok {
+		return nil, nil
+	}
+	message := newMessage()
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, &OTSClientError{Message: "Unmarshal response failed", Err: err}
+	}
+	return message, nil
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Xuyuanp/gots"
+	"github.com/Xuyuanp/gots/protobuf"
+)
+
+// traceInterceptor 在调用next前后向calls中记录name
+func traceInterceptor(calls *[]string, name string) gots.Interceptor {
+	return func(ctx context.Context, inv *gots.Invocation, next gots.Handler) error {
+		*calls = append(*calls, name+" "+inv.APIName)
+		err := next(ctx, inv)
+		*calls = append(*calls, name+" done")
+		return err
+	}
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	client, _ := newTestClient(t)
+	var calls []string
+	client.Interceptors = []gots.Interceptor{
+		traceInterceptor(&calls, "a"),
+		gots.ChainInterceptors(traceInterceptor(&calls, "b"), traceInterceptor(&calls, "c")),
+	}
+	if _, err := client.ListTable(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calls, ", "); got != "a ListTable, b ListTable, c ListTable, c done, b done, a done" {
+		t.Errorf("calls = %s, want interceptors nested in order", got)
+	}
+
+	calls = nil
+	client.Interceptors = []gots.Interceptor{gots.ChainInterceptors(), traceInterceptor(&calls, "a")}
+	if _, err := client.ListTable(); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 {
+		t.Errorf("calls = %v, want an empty chain to call next", calls)
+	}
+}
+
+func TestInterceptorInvocation(t *testing.T) {
+	client, _ := newTestClient(t)
+	createTestTable(t, client, "users", 10, 10)
+	var inv *gots.Invocation
+	client.Interceptors = []gots.Interceptor{func(ctx context.Context, i *gots.Invocation, next gots.Handler) error {
+		if i.StatusCode != 0 || i.Response != nil {
+			t.Errorf("response filled before the request is sent: %+v", i)
+		}
+		err := next(ctx, i)
+		inv = i
+		return err
+	}}
+	items := map[string]gots.BatchWriteRowItem{
+		"users": {PutRows: []*gots.PutRowInBatchWriteRowItem{
+			{Condition: ignore, PrimaryKey: testPrimaryKey(1, 1), Columns: map[string]interface{}{"name": "a"}},
+			{Condition: ignore, PrimaryKey: testPrimaryKey(1, 2), Columns: map[string]interface{}{"name": "b"}},
+		}},
+	}
+	if _, err := client.BatchWriteRow(items); err != nil {
+		t.Fatal(err)
+	}
+	if inv.APIName != "BatchWriteRow" || inv.Attempt != 1 || inv.Start.IsZero() || inv.Duration <= 0 {
+		t.Errorf("invocation = %+v", inv)
+	}
+	if _, ok := inv.Request.(*protobuf.BatchWriteRowRequest); !ok {
+		t.Errorf("request = %T, want *protobuf.BatchWriteRowRequest", inv.Request)
+	}
+	if inv.HTTPRequest == nil || !strings.HasSuffix(inv.HTTPRequest.URL.Path, "/BatchWriteRow") {
+		t.Errorf("HTTP request = %v", inv.HTTPRequest)
+	}
+	if inv.StatusCode != 200 || inv.RequestID == "" || len(inv.ResponseBody) == 0 {
+		t.Errorf("status = %d, request id = %q, body = %d bytes", inv.StatusCode, inv.RequestID, len(inv.ResponseBody))
+	}
+	if _, ok := inv.Response.(*protobuf.BatchWriteRowResponse); !ok {
+		t.Errorf("response = %T, want *protobuf.BatchWriteRowResponse", inv.Response)
+	}
+	if fmt.Sprint(inv.TableNames()) != "[users]" || inv.RowCount() != 2 {
+		t.Errorf("tables = %v, rows = %d, want [users] and 2", inv.TableNames(), inv.RowCount())
+	}
+	if cu := inv.ConsumedCapacity()["users"]; cu == nil || cu.Write < 2 {
+		t.Errorf("consumed = %v, want write capacity of both rows", cu)
+	}
+}
+
+func TestInterceptorHeaderAndShortCircuit(t *testing.T) {
+	client, srv := newTestClient(t)
+	var callers []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callers = append(callers, r.Header.Get("X-Caller"))
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client.EndPoint = ts.URL
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	errInjected := errors.New("injected")
+	inject := false
+	client.Interceptors = []gots.Interceptor{
+		func(ctx context.Context, inv *gots.Invocation, next gots.Handler) error {
+			inv.HTTPRequest.Header.Set("X-Caller", "test")
+			return next(ctx, inv)
+		},
+		func(ctx context.Context, inv *gots.Invocation, next gots.Handler) error {
+			if inject {
+				return errInjected
+			}
+			return next(ctx, inv)
+		},
+	}
+	if _, err := client.ListTable(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(callers) != "[test]" {
+		t.Errorf("X-Caller = %v, want the header added by the interceptor", callers)
+	}
+
+	inject = true
+	if _, err := client.ListTable(); !errors.Is(err, errInjected) {
+		t.Errorf("ListTable() = %v, want the injected error", err)
+	}
+	if len(callers) != 1 {
+		t.Errorf("requests = %d, want the short-circuited request not sent", len(callers))
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"reflect"
+)
+
+// RangeIterator 用于遍历范围内的所有行，会自动根据NextStartPrimaryKey读取下一页。
+// 通过Client.XGetRange创建
+type RangeIterator struct {
+	ctx             context.Context
+	client          *Client
+	req             GetRangeRequest
+	limit           int32
+	count           int32
+	consumed        *CapacityUnit
+	consumedCounter *CapacityUnit
+	lastNext        PrimaryKey
+	rows            []*Row
+	index           int
+	row             *Row
+	done            bool
+	err             error
+}
+
+// XGetRange 方法返回一个遍历指定主键范围的迭代器
+// req.Limit 为返回的总行数上限，为0时读取至范围结束
+// consumedCounter: 不为nil时，每一页消耗的读写吞吐量都会累加到其中
+// 示例:
+//
+//	consumed := &gots.CapacityUnit{}
+//	it := client.XGetRange(req, consumed)
+//	for it.Next() {
+//		row := it.Row()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+func (c *Client) XGetRange(req *GetRangeRequest, consumedCounter *CapacityUnit) *RangeIterator {
+	return c.XGetRangeWithContext(context.Background(), req, consumedCounter)
+}
+
+// XGetRangeWithContext 同XGetRange，ctx作用于迭代过程中的每一次请求
+func (c *Client) XGetRangeWithContext(ctx context.Context, req *GetRangeRequest, consumedCounter *CapacityUnit) *RangeIterator {
+	return &RangeIterator{
+		ctx:             ctx,
+		client:          c,
+		req:             *req,
+		limit:           req.Limit,
+		consumed:        &CapacityUnit{},
+		consumedCounter: consumedCounter,
+	}
+}
+
+// Next 将迭代器移动到下一行，没有更多数据或出错时返回false
+func (it *RangeIterator) Next() bool {
+	for {
+		if it.index < len(it.rows) {
+			it.row = it.rows[it.index]
+			it.index++
+			it.count++
+			return true
+		}
+		it.row = nil
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+}
+
+// Row 返回当前行
+func (it *RangeIterator) Row() *Row {
+	return it.row
+}
+
+// Err 返回迭代过程中发生的错误
+func (it *RangeIterator) Err() error {
+	return it.err
+}
+
+// Consumed 返回本次迭代已经消耗的读写吞吐量
+func (it *RangeIterator) Consumed() *CapacityUnit {
+	return it.consumed
+}
+
+func (it *RangeIterator) fetch() {
+	if it.limit > 0 {
+		remaining := it.limit - it.count
+		if remaining <= 0 {
+			it.done = true
+			return
+		}
+		it.req.Limit = remaining
+	}
+
+	resp, err := it.client.GetRangeWithContext(it.ctx, &it.req)
+	if err != nil {
+		it.err = err
+		return
+	}
+
+	cu := resp.Consumed.CapacityUnit
+	it.consumed.Read += cu.Read
+	it.consumed.Write += cu.Write
+	if it.consumedCounter != nil {
+		it.consumedCounter.Read += cu.Read
+		it.consumedCounter.Write += cu.Write
+	}
+
+	if it.req.Limit > 0 && len(resp.Rows) > int(it.req.Limit) {
+		resp.Rows = resp.Rows[:it.req.Limit]
+	}
+	it.rows = resp.Rows
+	it.index = 0
+
+	if !resp.HasNext() {
+		it.done = true
+		return
+	}
+	if len(resp.Rows) == 0 && reflect.DeepEqual(resp.NextStartPrimaryKey, it.lastNext) {
+		it.err = &OTSClientError{Message: "GetRange makes no progress, NextStartPrimaryKey is not changed"}
+		return
+	}
+	it.lastNext = resp.NextStartPrimaryKey
+	it.req.InclusiveStartPrimaryKey = resp.NextStartPrimaryKey
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func fullRange() *GetRangeRequest {
+	return &GetRangeRequest{
+		TableName:                "users",
+		InclusiveStartPrimaryKey: NewPrimaryKey().Add("uid", INFMin),
+		ExclusiveEndPrimaryKey:   NewPrimaryKey().Add("uid", INFMax),
+	}
+}
+
+func TestXGetRange(t *testing.T) {
+	var requests []*protobuf.GetRangeRequest
+	client := newFakeClient(t, fakeRangeHandler(5, 2, &requests))
+
+	tests := []struct {
+		limit    int32
+		want     string
+		requests int
+	}{
+		{0, "[1 2 3 4 5]", 3},
+		{3, "[1 2 3]", 2},
+		{2, "[1 2]", 1},
+	}
+	for _, tt := range tests {
+		requests = nil
+		req := fullRange()
+		req.Limit = tt.limit
+		counter := &CapacityUnit{Read: 100}
+		it := client.XGetRange(req, counter)
+		var rows []*Row
+		for it.Next() {
+			rows = append(rows, it.Row())
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if uids := fmt.Sprint(rowUIDs(rows)); uids != tt.want {
+			t.Errorf("limit %d: rows = %s, want %s", tt.limit, uids, tt.want)
+		}
+		if len(requests) != tt.requests {
+			t.Errorf("limit %d: %d requests, want %d", tt.limit, len(requests), tt.requests)
+		}
+		if consumed := it.Consumed(); consumed.Read != int32(tt.requests) || counter.Read != 100+consumed.Read {
+			t.Errorf("limit %d: consumed %+v, counter %+v, want counter to accumulate consumed", tt.limit, consumed, counter)
+		}
+	}
+}
+
+func TestXGetRangeError(t *testing.T) {
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		return fakeError(404, "OTSObjectNotExist")
+	})
+	it := client.XGetRange(fullRange(), nil)
+	if it.Next() {
+		t.Fatal("Next() = true on missing table")
+	}
+	if serviceErr, ok := it.Err().(*OTSServiceError); !ok || serviceErr.Code != "OTSObjectNotExist" {
+		t.Errorf("Err() = %v, want OTSObjectNotExist", it.Err())
+	}
+}
+
+func TestXGetRangeNoProgress(t *testing.T) {
+	// 服务端始终返回相同的NextStartPrimaryKey且没有数据
+	requests := 0
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		requests++
+		return 200, &protobuf.GetRangeResponse{
+			Consumed:            consumed(1, 0),
+			NextStartPrimaryKey: []*protobuf.Column{intColumn("uid", 1)},
+		}
+	})
+
+	it := client.XGetRange(fullRange(), nil)
+	for it.Next() {
+	}
+	if it.Err() == nil || requests != 2 {
+		t.Errorf("Err() = %v after %d requests, want no progress error after 2", it.Err(), requests)
+	}
+}
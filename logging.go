@@ -0,0 +1,226 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// DefaultLogMaxValueLength 为记录请求内容时字符串和二进制值的默认最大长度
+const DefaultLogMaxValueLength = 64
+
+// redactedValue 为被隐藏的值在日志中的替代内容
+const redactedValue = "[REDACTED]"
+
+// credentialHeaders 为记录日志时需要隐藏的凭证相关请求头
+var credentialHeaders = map[string]bool{
+	HeaderOTSAccessKeyID: true,
+	HeaderOTSSignature:   true,
+	HeaderOTSSTSToken:    true,
+	"authorization":      true,
+}
+
+// Logger 为分级的结构化日志接口，args为交替的键和值，*slog.Logger 直接实现了该接口
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// LogOptions 控制请求内容的日志记录
+type LogOptions struct {
+	// Payload 为true时以Debug级别记录请求头、请求和响应内容
+	Payload bool
+	// MaxValueLength 为字符串和二进制值的最大长度，超出部分被截断，为0时使用DefaultLogMaxValueLength，小于0时不截断
+	MaxValueLength int
+	// RedactColumns 中的列的值会被替换为[REDACTED]
+	RedactColumns []string
+}
+
+func (o *LogOptions) maxValueLength() int {
+	if o.MaxValueLength == 0 {
+		return DefaultLogMaxValueLength
+	}
+	return o.MaxValueLength
+}
+
+func (o *LogOptions) redacted(name string) bool {
+	for _, column := range o.RedactColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stdLogger 将*log.Logger适配为Logger
+type stdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger 返回输出到*log.Logger的Logger，每条日志格式为"LEVEL msg key=value ..."
+func NewStdLogger(logger *log.Logger) Logger {
+	return &stdLogger{logger: logger}
+}
+
+func (l *stdLogger) log(level, msg string, args []any) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+		}
+	}
+	l.logger.Print(b.String())
+}
+
+func (l *stdLogger) Debug(msg string, args ...any) { l.log("DEBUG", msg, args) }
+func (l *stdLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args) }
+func (l *stdLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args) }
+func (l *stdLogger) Error(msg string, args ...any) { l.log("ERROR", msg, args) }
+
+// logAttempt 记录一次请求尝试，成功时为Debug级别，将重试时为Warn级别，最终失败时为Error级别
+func (c *Client) logAttempt(apiName string, message proto.Message, inv *Invocation, err error, retrying bool) {
+	if c.Logger == nil {
+		return
+	}
+	args := []any{
+		"api", apiName,
+		"table", strings.Join(requestTableNames(message), ","),
+	}
+	if inv != nil {
+		args = append(args,
+			"attempt", inv.Attempt,
+			"latency", inv.Duration,
+			"status", inv.StatusCode,
+			"request_id", inv.RequestID,
+		)
+	}
+	if err != nil {
+		args = append(args, "error_code", ErrorCode(err), "error", err.Error())
+	}
+
+	switch {
+	case err == nil:
+		c.Logger.Debug("ots request", args...)
+	case retrying:
+		c.Logger.Warn("ots request failed, retrying", args...)
+	default:
+		c.Logger.Error("ots request failed", args...)
+	}
+}
+
+// logPayload 在LogOptions.Payload为true时以Debug级别记录一次尝试的请求头、请求和响应内容
+func (c *Client) logPayload(apiName string, message proto.Message, inv *Invocation) {
+	if c.Logger != nil && c.LogOptions.Payload && inv != nil {
+		payload := []any{
+			"api", apiName,
+			"request_id", inv.RequestID,
+			"headers", c.LogOptions.formatHeaders(inv.HTTPRequest.Header),
+			"request", c.LogOptions.formatMessage(message),
+		}
+		if inv.Response != nil {
+			payload = append(payload, "response", c.LogOptions.formatMessage(inv.Response))
+		}
+		c.Logger.Debug("ots payload", payload...)
+	}
+}
+
+// formatHeaders 返回隐藏了凭证的请求头
+func (o *LogOptions) formatHeaders(header http.Header) string {
+	lines := make([]string, 0, len(header))
+	for k, v := range lowerHeaders(header) {
+		if credentialHeaders[k] {
+			v = redactedValue
+		}
+		lines = append(lines, k+":"+v)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, " ")
+}
+
+// formatMessage 返回截断了过长值并隐藏了RedactColumns的消息文本
+func (o *LogOptions) formatMessage(message proto.Message) string {
+	if message == nil || reflect.ValueOf(message).IsNil() {
+		return ""
+	}
+	message = proto.Clone(message)
+	o.sanitize(reflect.ValueOf(message))
+	return proto.CompactTextString(message)
+}
+
+// sanitize 遍历消息，处理其中所有Column和ColumnUpdate的值
+func (o *LogOptions) sanitize(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		switch m := v.Interface().(type) {
+		case *protobuf.Column:
+			o.sanitizeValue(m.GetName(), m.Value)
+			return
+		case *protobuf.ColumnUpdate:
+			o.sanitizeValue(m.GetName(), m.Value)
+			return
+		}
+		o.sanitize(v.Elem())
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			o.sanitize(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				o.sanitize(v.Field(i))
+			}
+		}
+	}
+}
+
+func (o *LogOptions) sanitizeValue(name string, value *protobuf.ColumnValue) {
+	if value == nil {
+		return
+	}
+	if o.redacted(name) {
+		*value = protobuf.ColumnValue{Type: value.Type, VString: proto.String(redactedValue)}
+		return
+	}
+	limit := o.maxValueLength()
+	if limit < 0 {
+		return
+	}
+	if value.VString != nil && len(*value.VString) > limit {
+		value.VString = proto.String(fmt.Sprintf("%s...(%d bytes)", (*value.VString)[:limit], len(*value.VString)))
+	}
+	if len(value.VBinary) > limit {
+		value.VBinary = append(value.VBinary[:limit:limit], fmt.Sprintf("...(%d bytes)", len(value.VBinary))...)
+	}
+}
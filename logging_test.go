@@ -0,0 +1,149 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// recordLogger 记录每条日志的级别和消息
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordLogger) log(level, msg string) {
+	l.mu.Lock()
+	l.entries = append(l.entries, fmt.Sprintf("%s %s", level, msg))
+	l.mu.Unlock()
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.log("DEBUG", msg) }
+func (l *recordLogger) Info(msg string, args ...any)  { l.log("INFO", msg) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.log("WARN", msg) }
+func (l *recordLogger) Error(msg string, args ...any) { l.log("ERROR", msg) }
+
+func (l *recordLogger) Entries() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.entries...)
+}
+
+// newLoggingClient 返回所有请求都由handler处理的Client
+func newLoggingClient(t *testing.T, handler Interceptor) (*Client, *recordLogger) {
+	t.Helper()
+	c := NewClient("http://127.0.0.1:1", "id", "key", "instance")
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	logger := &recordLogger{}
+	c.Logger = logger
+	c.Interceptors = []Interceptor{handler}
+	return c, logger
+}
+
+func TestLogRetryCanceledByContext(t *testing.T) {
+	c, logger := newLoggingClient(t, func(ctx context.Context, inv *Invocation, next Handler) error {
+		return &OTSServiceError{Status: 503, Code: ErrorCodeServerBusy}
+	})
+	policy := NewDefaultRetryPolicy()
+	policy.InitialInterval = time.Hour
+	policy.MaxInterval = time.Hour
+	policy.MaxElapsedTime = 0
+	c.RetryPolicy = policy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.ListTableWithContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ListTable() = %v, want context.DeadlineExceeded", err)
+	}
+	want := []string{"WARN ots request failed, retrying", "ERROR ots request failed"}
+	if got := logger.Entries(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("log entries = %q, want %q", got, want)
+	}
+}
+
+func TestLogFinalOutcome(t *testing.T) {
+	attempts := 0
+	c, logger := newLoggingClient(t, func(ctx context.Context, inv *Invocation, next Handler) error {
+		attempts++
+		if attempts == 1 {
+			return &OTSServiceError{Status: 503, Code: ErrorCodeServerBusy}
+		}
+		var err error
+		inv.ResponseBody, err = proto.Marshal(&protobuf.ListTableResponse{})
+		return err
+	})
+	policy := NewDefaultRetryPolicy()
+	policy.InitialInterval = -1
+	c.RetryPolicy = policy
+	c.LogOptions.Payload = true
+
+	if _, err := c.ListTable(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DEBUG ots payload", "WARN ots request failed, retrying", "DEBUG ots payload", "DEBUG ots request"}
+	if got := logger.Entries(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("log entries = %q, want %q", got, want)
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	o := &LogOptions{MaxValueLength: 4, RedactColumns: []string{"password"}}
+	message := &protobuf.PutRowRequest{
+		TableName: proto.String("users"),
+		AttributeColumns: []*protobuf.Column{
+			{Name: proto.String("password"), Value: &protobuf.ColumnValue{Type: protobuf.ColumnType_STRING.Enum(), VString: proto.String("secret")}},
+			{Name: proto.String("name"), Value: &protobuf.ColumnValue{Type: protobuf.ColumnType_STRING.Enum(), VString: proto.String("abcdefgh")}},
+		},
+	}
+	text := o.formatMessage(message)
+	if strings.Contains(text, "secret") || !strings.Contains(text, redactedValue) {
+		t.Errorf("formatMessage() = %s, want password redacted", text)
+	}
+	if !strings.Contains(text, "abcd...(8 bytes)") {
+		t.Errorf("formatMessage() = %s, want name truncated", text)
+	}
+	if message.AttributeColumns[0].Value.GetVString() != "secret" {
+		t.Error("formatMessage() modified the original message")
+	}
+}
+
+func TestFormatHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set(HeaderOTSSignature, "signature")
+	header.Set(HeaderOTSSTSToken, "token")
+	header.Set(HeaderOTSAPIVersion, DefaultAPIVersion)
+	text := (&LogOptions{}).formatHeaders(header)
+	if strings.Contains(text, ":signature") || strings.Contains(text, ":token") || !strings.Contains(text, HeaderOTSSignature+":"+redactedValue) {
+		t.Errorf("formatHeaders() = %s, want credentials redacted", text)
+	}
+	if !strings.Contains(text, HeaderOTSAPIVersion+":"+DefaultAPIVersion) {
+		t.Errorf("formatHeaders() = %s, want api version kept", text)
+	}
+}
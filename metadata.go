@@ -16,7 +16,11 @@
 
 package gots
 
-import "github.com/Xuyuanp/gots/protobuf"
+import (
+	"fmt"
+
+	"github.com/Xuyuanp/gots/protobuf"
+)
 
 type ColumnType int32
 
@@ -114,11 +118,25 @@ var DirectionValue = map[string]Direction{
 	"BACKWARD": DirectionBackward,
 }
 
+func (d Direction) String() string {
+	return DirectionName[d]
+}
+
+func (d Direction) Unparse() *protobuf.Direction {
+	pbD := new(protobuf.Direction)
+	*pbD = protobuf.Direction(d)
+	return pbD
+}
+
 type Error struct {
 	Code    string
 	Message string
 }
 
+func (e *Error) Error() string {
+	return fmt.Sprintf("ErrorCode: %s, ErrorMessage: %s", e.Code, e.Message)
+}
+
 func (e *Error) Parse(pbE *protobuf.Error) *Error {
 	e.Code = pbE.GetCode()
 	e.Message = pbE.GetMessage()
@@ -166,9 +184,17 @@ type ColumnValue struct {
 	VBinary []byte
 }
 
+// INFMin 和 INFMax 仅用于GetRange，分别表示主键列的无穷小和无穷大
+var (
+	INFMin = &ColumnValue{Type: ColumnTypeINFMin}
+	INFMax = &ColumnValue{Type: ColumnTypeINFMax}
+)
+
 func NewColumnValue(v interface{}) *ColumnValue {
 	cv := &ColumnValue{}
 	switch v.(type) {
+	case *ColumnValue:
+		cv = v.(*ColumnValue)
 	case int64:
 		cv.Type = ColumnTypeInteger
 		cv.VInt = v.(int64)
@@ -238,6 +264,10 @@ func (cv *ColumnValue) Value() interface{} {
 		return cv.VBool
 	case ColumnTypeBinary:
 		return cv.VBinary
+	case ColumnTypeINFMin:
+		return INFMin
+	case ColumnTypeINFMax:
+		return INFMax
 	}
 	return nil
 }
@@ -261,6 +291,15 @@ func ColumnsFromMap(colMap map[string]interface{}) []*Column {
 	return columns
 }
 
+// ColumnsToMap 将列转换为列名到列值的映射
+func ColumnsToMap(columns []*Column) map[string]interface{} {
+	colMap := make(map[string]interface{}, len(columns))
+	for _, col := range columns {
+		colMap[col.Name] = col.Value.Value()
+	}
+	return colMap
+}
+
 func (col *Column) Parse(pbCol *protobuf.Column) *Column {
 	col.Name = pbCol.GetName()
 	col.Value = (&ColumnValue{}).Parse(pbCol.GetValue())
@@ -276,11 +315,66 @@ func (col *Column) Unparse() *protobuf.Column {
 	return pbCol
 }
 
+// PrimaryKey 是按表结构定义顺序排列的主键列。
+// 示例:
+//
+//	pk := gots.NewPrimaryKey().Add("gid", 1).Add("uid", 101)
+type PrimaryKey []*Column
+
+// NewPrimaryKey 返回一个空的主键
+func NewPrimaryKey() PrimaryKey {
+	return PrimaryKey{}
+}
+
+// PrimaryKeyFromMap 由映射创建主键，映射是无序的，因此仅适用于只有一个主键列的表
+func PrimaryKeyFromMap(colMap map[string]interface{}) PrimaryKey {
+	return PrimaryKey(ColumnsFromMap(colMap))
+}
+
+// Add 在主键末尾追加一列并返回新的主键，不会修改pk，因此可以由同一前缀构造多个主键
+func (pk PrimaryKey) Add(name string, value interface{}) PrimaryKey {
+	return append(pk[:len(pk):len(pk)], &Column{
+		Name:  name,
+		Value: NewColumnValue(value),
+	})
+}
+
+// Get 返回指定主键列的值，不存在时返回nil
+func (pk PrimaryKey) Get(name string) *ColumnValue {
+	for _, col := range pk {
+		if col.Name == name {
+			return col.Value
+		}
+	}
+	return nil
+}
+
+func (pk PrimaryKey) Parse(pbCols []*protobuf.Column) PrimaryKey {
+	pk = make(PrimaryKey, len(pbCols))
+	for i, pbCol := range pbCols {
+		pk[i] = (&Column{}).Parse(pbCol)
+	}
+	return pk
+}
+
+func (pk PrimaryKey) Unparse() []*protobuf.Column {
+	pbCols := make([]*protobuf.Column, len(pk))
+	for i, col := range pk {
+		pbCols[i] = col.Unparse()
+	}
+	return pbCols
+}
+
 type Row struct {
 	PrimaryKeyColumns []*Column
 	AttributeColumns  []*Column
 }
 
+// PrimaryKey 返回该行的主键
+func (r *Row) PrimaryKey() PrimaryKey {
+	return PrimaryKey(r.PrimaryKeyColumns)
+}
+
 func (r *Row) Parse(pbRow *protobuf.Row) *Row {
 	r.PrimaryKeyColumns = make([]*Column, len(pbRow.GetPrimaryKeyColumns()))
 	r.AttributeColumns = make([]*Column, len(pbRow.GetAttributeColumns()))
@@ -465,7 +559,7 @@ func (drr *DeleteRowResponse) Parse(pbDRR *protobuf.DeleteRowResponse) *DeleteRo
 }
 
 type BatchGetRowItem struct {
-	PrimaryKeys []map[string]interface{}
+	PrimaryKeys []PrimaryKey
 	ColumnNames []string
 }
 
@@ -510,25 +604,148 @@ func (bgrr *BatchGetRowResponse) Parse(pbBGRR *protobuf.BatchGetRowResponse) *Ba
 	return bgrr
 }
 
+// Table 返回指定表的读取结果，表不存在时返回nil
+func (bgrr *BatchGetRowResponse) Table(name string) *TableInBatchGetRowResponse {
+	for _, t := range bgrr.Tables {
+		if t.TableName == name {
+			return t
+		}
+	}
+	return nil
+}
+
+type PutRowInBatchWriteRowItem struct {
+	Condition  *Condition
+	PrimaryKey PrimaryKey
+	Columns    map[string]interface{}
+}
+
+type UpdateRowInBatchWriteRowItem struct {
+	Condition     *Condition
+	PrimaryKey    PrimaryKey
+	ColumnsPut    map[string]interface{}
+	ColumnsDelete []string
+}
+
+type DeleteRowInBatchWriteRowItem struct {
+	Condition  *Condition
+	PrimaryKey PrimaryKey
+}
+
+type BatchWriteRowItem struct {
+	PutRows    []*PutRowInBatchWriteRowItem
+	UpdateRows []*UpdateRowInBatchWriteRowItem
+	DeleteRows []*DeleteRowInBatchWriteRowItem
+}
+
 type RowInBatchWriteRowResponse struct {
 	IsOk     bool
 	Error    *Error
 	Consumed *ConsumedCapacity
 }
 
+func (rwrr *RowInBatchWriteRowResponse) Parse(pbRWRR *protobuf.RowInBatchWriteRowResponse) *RowInBatchWriteRowResponse {
+	rwrr.IsOk = pbRWRR.GetIsOk()
+	rwrr.Consumed = (&ConsumedCapacity{}).Parse(pbRWRR.GetConsumed())
+	rwrr.Error = (&Error{}).Parse(pbRWRR.GetError())
+	return rwrr
+}
+
+// TableInBatchWriteRowResponse 中PutRows、UpdateRows、DeleteRows与Item中对应的行按下标一一对应
 type TableInBatchWriteRowResponse struct {
 	TableName  string
+	Item       BatchWriteRowItem
 	PutRows    []*RowInBatchWriteRowResponse
 	UpdateRows []*RowInBatchWriteRowResponse
 	DeleteRows []*RowInBatchWriteRowResponse
 }
 
+func parseRowsInBatchWriteRowResponse(pbRows []*protobuf.RowInBatchWriteRowResponse) []*RowInBatchWriteRowResponse {
+	rows := make([]*RowInBatchWriteRowResponse, len(pbRows))
+	for i, row := range pbRows {
+		rows[i] = (&RowInBatchWriteRowResponse{}).Parse(row)
+	}
+	return rows
+}
+
+func (twrr *TableInBatchWriteRowResponse) Parse(pbTWRR *protobuf.TableInBatchWriteRowResponse) *TableInBatchWriteRowResponse {
+	twrr.TableName = pbTWRR.GetTableName()
+	twrr.PutRows = parseRowsInBatchWriteRowResponse(pbTWRR.GetPutRows())
+	twrr.UpdateRows = parseRowsInBatchWriteRowResponse(pbTWRR.GetUpdateRows())
+	twrr.DeleteRows = parseRowsInBatchWriteRowResponse(pbTWRR.GetDeleteRows())
+	return twrr
+}
+
 type BatchWriteRowResponse struct {
 	Tables []*TableInBatchWriteRowResponse
 }
 
+func (bwrr *BatchWriteRowResponse) Parse(pbBWRR *protobuf.BatchWriteRowResponse) *BatchWriteRowResponse {
+	bwrr.Tables = make([]*TableInBatchWriteRowResponse, len(pbBWRR.GetTables()))
+	for i, t := range pbBWRR.GetTables() {
+		bwrr.Tables[i] = (&TableInBatchWriteRowResponse{}).Parse(t)
+	}
+	return bwrr
+}
+
+// Table 返回指定表的写入结果，表不存在时返回nil
+func (bwrr *BatchWriteRowResponse) Table(name string) *TableInBatchWriteRowResponse {
+	for _, t := range bwrr.Tables {
+		if t.TableName == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// bind 将请求中的条目绑定到对应表的结果上，并校验每张表返回的行数与请求一致
+func (bwrr *BatchWriteRowResponse) bind(items map[string]BatchWriteRowItem) error {
+	if len(bwrr.Tables) != len(items) {
+		return &OTSClientError{Message: fmt.Sprintf("BatchWriteRow response has %d tables, %d expected", len(bwrr.Tables), len(items))}
+	}
+	for _, t := range bwrr.Tables {
+		item, ok := items[t.TableName]
+		if !ok {
+			return &OTSClientError{Message: fmt.Sprintf("Unexpected table %s in BatchWriteRow response", t.TableName)}
+		}
+		if len(t.PutRows) != len(item.PutRows) ||
+			len(t.UpdateRows) != len(item.UpdateRows) ||
+			len(t.DeleteRows) != len(item.DeleteRows) {
+			return &OTSClientError{Message: fmt.Sprintf("Rows count mismatch for table %s in BatchWriteRow response", t.TableName)}
+		}
+		t.Item = item
+	}
+	return nil
+}
+
+// GetRangeRequest 描述一次范围查询，查询区间为[InclusiveStartPrimaryKey, ExclusiveEndPrimaryKey)
+// 起止主键必须包含所有主键列，可以使用INFMin和INFMax作为列值。Limit为0时不限制返回行数
+type GetRangeRequest struct {
+	TableName                string
+	Direction                Direction
+	InclusiveStartPrimaryKey PrimaryKey
+	ExclusiveEndPrimaryKey   PrimaryKey
+	ColumnNames              []string
+	Limit                    int32
+}
+
 type GetRangeResponse struct {
 	Consumed            *ConsumedCapacity
-	NextStartPrimaryKey []*Column
+	NextStartPrimaryKey PrimaryKey
 	Rows                []*Row
 }
+
+func (grr *GetRangeResponse) Parse(pbGRR *protobuf.GetRangeResponse) *GetRangeResponse {
+	grr.Consumed = (&ConsumedCapacity{}).Parse(pbGRR.GetConsumed())
+	grr.NextStartPrimaryKey = grr.NextStartPrimaryKey.Parse(pbGRR.GetNextStartPrimaryKey())
+	grr.Rows = make([]*Row, len(pbGRR.GetRows()))
+	for i, row := range pbGRR.GetRows() {
+		grr.Rows[i] = (&Row{}).Parse(row)
+	}
+	return grr
+}
+
+// HasNext 返回范围内是否还有未读取的数据
+func (grr *GetRangeResponse) HasNext() bool {
+	return len(grr.NextStartPrimaryKey) > 0
+}
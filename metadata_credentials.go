@@ -0,0 +1,191 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMetadataURL 为实例元数据服务中RAM角色临时凭证的地址
+	DefaultMetadataURL = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+	// DefaultMetadataTimeout 为访问实例元数据服务的超时时间
+	DefaultMetadataTimeout = 5 * time.Second
+	// DefaultMetadataJitter 为临时凭证刷新时间的最大随机提前量
+	DefaultMetadataJitter = time.Minute
+)
+
+// MetadataCredentialsProvider 从实例元数据服务获取RAM角色的临时凭证。
+// 先请求BaseURL获取角色名，再请求BaseURL+角色名获取如下格式的凭证：
+//
+//	{
+//	    "Code": "Success",
+//	    "AccessKeyId": "...",
+//	    "AccessKeySecret": "...",
+//	    "SecurityToken": "...",
+//	    "Expiration": "2017-11-01T05:20:01Z"
+//	}
+//
+// 凭证会被缓存，并在过期前RefreshWindow减去随机Jitter时刷新
+type MetadataCredentialsProvider struct {
+	// BaseURL 为元数据服务地址，为空时使用DefaultMetadataURL
+	BaseURL string
+	// RoleName 为RAM角色名，为空时从元数据服务获取
+	RoleName string
+	// HTTPClient 为nil时使用超时为DefaultMetadataTimeout的http.Client
+	HTTPClient *http.Client
+	// RefreshWindow 为过期前提前刷新的时间，为0时使用DefaultRefreshWindow
+	RefreshWindow time.Duration
+	// Jitter 为刷新时间的最大随机提前量，为0时使用DefaultMetadataJitter，小于0时不使用
+	Jitter time.Duration
+
+	once      sync.Once
+	refresher *RefreshingCredentialsProvider
+	roleMu    sync.Mutex
+	role      string
+}
+
+// NewMetadataCredentialsProvider 创建使用baseURL的MetadataCredentialsProvider
+func NewMetadataCredentialsProvider(baseURL string) *MetadataCredentialsProvider {
+	return &MetadataCredentialsProvider{BaseURL: baseURL}
+}
+
+// Credentials 返回缓存的临时凭证，临近过期时从元数据服务刷新
+func (p *MetadataCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	p.once.Do(func() {
+		jitter := p.Jitter
+		if jitter == 0 {
+			jitter = DefaultMetadataJitter
+		} else if jitter < 0 {
+			jitter = 0
+		}
+		p.refresher = &RefreshingCredentialsProvider{
+			Fetch:         p.fetch,
+			RefreshWindow: p.RefreshWindow,
+			Jitter:        jitter,
+		}
+	})
+	return p.refresher.Credentials(ctx)
+}
+
+func (p *MetadataCredentialsProvider) baseURL() string {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultMetadataURL
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return baseURL
+}
+
+func (p *MetadataCredentialsProvider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return &http.Client{Timeout: DefaultMetadataTimeout}
+}
+
+// get 请求元数据服务并返回响应内容
+func (p *MetadataCredentialsProvider) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Invalid metadata url %s", url), Err: err}
+	}
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Request metadata %s failed", url), Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Read metadata %s failed", url), Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &OTSClientError{Status: resp.StatusCode, Message: fmt.Sprintf("Request metadata %s failed with status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(data)))}
+	}
+	return data, nil
+}
+
+// roleName 返回RAM角色名，从元数据服务获取的角色名会被缓存
+func (p *MetadataCredentialsProvider) roleName(ctx context.Context) (string, error) {
+	if p.RoleName != "" {
+		return p.RoleName, nil
+	}
+	p.roleMu.Lock()
+	defer p.roleMu.Unlock()
+	if p.role != "" {
+		return p.role, nil
+	}
+	data, err := p.get(ctx, p.baseURL())
+	if err != nil {
+		return "", err
+	}
+	role := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if role == "" {
+		return "", &OTSClientError{Message: "No RAM role attached to instance"}
+	}
+	p.role = role
+	return role, nil
+}
+
+type metadataCredentials struct {
+	Code            string
+	Message         string
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string
+	SecurityToken   string
+	Expiration      string
+}
+
+// fetch 从元数据服务获取新的临时凭证
+func (p *MetadataCredentialsProvider) fetch(ctx context.Context) (*Credentials, error) {
+	role, err := p.roleName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.get(ctx, p.baseURL()+role)
+	if err != nil {
+		return nil, err
+	}
+	var result metadataCredentials
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, &OTSClientError{Message: "Invalid credentials from metadata", Err: err}
+	}
+	if result.Code != "" && result.Code != "Success" {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Get credentials from metadata failed: %s %s", result.Code, result.Message)}
+	}
+	creds := &Credentials{
+		AccessID:      result.AccessKeyID,
+		AccessKey:     result.AccessKeySecret,
+		SecurityToken: result.SecurityToken,
+	}
+	if result.Expiration != "" {
+		creds.Expiration, err = time.Parse(time.RFC3339, result.Expiration)
+		if err != nil {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Invalid expiration %s from metadata", result.Expiration), Err: err}
+		}
+	}
+	return creds, nil
+}
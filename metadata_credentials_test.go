@@ -0,0 +1,155 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots"
+	"github.com/Xuyuanp/gots/gotstest"
+)
+
+const testMetadataPath = "/latest/meta-data/ram/security-credentials/"
+
+// newMetadataServer 启动签发testAccessID和testAccessKey的元数据服务，返回服务和角色名请求数
+func newMetadataServer(t *testing.T) (*gotstest.MetadataServer, string, *int32) {
+	t.Helper()
+	meta := gotstest.NewMetadataServer("role", testAccessID, testAccessKey)
+	roleRequests := new(int32)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			atomic.AddInt32(roleRequests, 1)
+		}
+		meta.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return meta, ts.URL + testMetadataPath, roleRequests
+}
+
+// tokenRecorder 记录每个请求中的x-ots-ststoken头
+type tokenRecorder struct {
+	tokens []string
+}
+
+func (r *tokenRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.tokens = append(r.tokens, req.Header.Get(gots.HeaderOTSSTSToken))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestMetadataCredentialsProvider(t *testing.T) {
+	meta, url, roleRequests := newMetadataServer(t)
+	client, _ := newTestClient(t)
+	// 清空静态凭证，请求只能通过元数据服务签发的凭证签名
+	client.AccessID, client.AccessKey = "", ""
+	client.Credentials = gots.NewMetadataCredentialsProvider(url)
+	rt := &tokenRecorder{}
+	client.Transport = rt
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	createTestTable(t, client, "users", 10, 10)
+	if _, err := client.ListTable(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rt.tokens, " ") != "token-1 token-1" {
+		t.Errorf("security tokens = %v, want the cached token sent with every request", rt.tokens)
+	}
+	if n := atomic.LoadInt32(roleRequests); meta.Issued() != 1 || n != 1 {
+		t.Errorf("issued = %d, role requests = %d, want credentials fetched once", meta.Issued(), n)
+	}
+}
+
+func TestMetadataCredentialsRefresh(t *testing.T) {
+	meta, url, roleRequests := newMetadataServer(t)
+	// 凭证的有效期短于RefreshWindow，每次获取凭证都会触发刷新
+	meta.TTL = time.Minute
+	p := gots.NewMetadataCredentialsProvider(url)
+	p.RefreshWindow = 2 * time.Minute
+	p.Jitter = -1
+	ctx := context.Background()
+
+	creds, err := p.Credentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessID != testAccessID || creds.AccessKey != testAccessKey || creds.SecurityToken != "token-1" {
+		t.Fatalf("Credentials() = %+v", creds)
+	}
+	if d := time.Until(creds.Expiration); d <= 0 || d > time.Minute {
+		t.Errorf("expiration in %v, want the one issued by the metadata server", d)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for meta.Issued() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("credentials not refreshed ahead of expiry")
+		}
+		if _, err := p.Credentials(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(roleRequests); n != 1 {
+		t.Errorf("role requests = %d, want the role name cached", n)
+	}
+}
+
+func TestMetadataCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		handler http.HandlerFunc
+		err     string
+	}{
+		{"no role", "", func(w http.ResponseWriter, r *http.Request) {}, "No RAM role attached"},
+		{"unknown role", "", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/") {
+				w.Write([]byte("role"))
+				return
+			}
+			http.NotFound(w, r)
+		}, "failed with status 404"},
+		{"failure code", "", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/") {
+				w.Write([]byte("role"))
+				return
+			}
+			w.Write([]byte(`{"Code": "Failed", "Message": "role revoked"}`))
+		}, "Failed role revoked"},
+		{"invalid expiration", "role", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Code": "Success", "AccessKeyId": "id", "AccessKeySecret": "key", "Expiration": "tomorrow"}`))
+		}, "Invalid expiration tomorrow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+			p := gots.NewMetadataCredentialsProvider(ts.URL + testMetadataPath)
+			p.RoleName = tt.role
+			if _, err := p.Credentials(context.Background()); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Credentials() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
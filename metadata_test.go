@@ -0,0 +1,50 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import "testing"
+
+func TestPrimaryKeyAddSharedPrefix(t *testing.T) {
+	prefix := NewPrimaryKey().Add("a", int64(1)).Add("b", "x").Add("c", true)
+	start := prefix.Add("d", INFMin)
+	end := prefix.Add("d", INFMax)
+
+	if len(prefix) != 3 {
+		t.Fatalf("prefix modified: %d columns", len(prefix))
+	}
+	if got := start.Get("d"); got != INFMin {
+		t.Errorf("start d = %v, want INF_MIN", got)
+	}
+	if got := end.Get("d"); got != INFMax {
+		t.Errorf("end d = %v, want INF_MAX", got)
+	}
+}
+
+func TestPrimaryKeyOrder(t *testing.T) {
+	pk := NewPrimaryKey().Add("uid", int64(2)).Add("gid", int64(1))
+	pbCols := pk.Unparse()
+	if pbCols[0].GetName() != "uid" || pbCols[1].GetName() != "gid" {
+		t.Fatalf("order not preserved: %v", pbCols)
+	}
+	parsed := PrimaryKey{}.Parse(pbCols)
+	if parsed[0].Name != "uid" || parsed.Get("gid").Value() != int64(1) {
+		t.Fatalf("round trip mismatch: %v", parsed)
+	}
+	if pk.Get("nope") != nil {
+		t.Errorf("Get of missing column should be nil")
+	}
+}
@@ -0,0 +1,330 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsClientErrorCode 为非服务端错误（如网络错误）在指标中使用的错误码
+const MetricsClientErrorCode = "OTSClientError"
+
+// DefaultLatencyBuckets 为延迟直方图默认的桶上界，单位为秒
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// RequestMetrics 为一次请求尝试的指标
+type RequestMetrics struct {
+	APIName string
+	// TableNames 为请求涉及的表名，按表名排序，ListTable为空
+	TableNames []string
+	Duration   time.Duration
+	// ErrorCode 为空表示请求成功
+	ErrorCode     string
+	BytesSent     int64
+	BytesReceived int64
+	// Consumed 为各表消耗的读写能力单元
+	Consumed map[string]*CapacityUnit
+}
+
+// Metrics 接收每一次请求尝试的指标，实现需要并发安全
+type Metrics interface {
+	ObserveRequest(m *RequestMetrics)
+}
+
+// MetricsInterceptor 返回将每次请求尝试的指标记录到m的拦截器。
+// 示例:
+//
+//	metrics := gots.NewMemoryMetrics()
+//	client.Interceptors = append(client.Interceptors, gots.MetricsInterceptor(metrics))
+//	http.Handle("/metrics", metrics)
+func MetricsInterceptor(m Metrics) Interceptor {
+	return func(ctx context.Context, inv *Invocation, next Handler) error {
+		err := next(ctx, inv)
+		rm := &RequestMetrics{
+			APIName:       inv.APIName,
+			TableNames:    inv.TableNames(),
+			Duration:      inv.Duration,
+			BytesReceived: int64(len(inv.ResponseBody)),
+			Consumed:      inv.ConsumedCapacity(),
+		}
+		if inv.HTTPRequest != nil && inv.HTTPRequest.ContentLength > 0 {
+			rm.BytesSent = inv.HTTPRequest.ContentLength
+		}
+		if err != nil {
+			rm.ErrorCode = ErrorCode(err)
+			if rm.ErrorCode == "" {
+				rm.ErrorCode = MetricsClientErrorCode
+			}
+		}
+		m.ObserveRequest(rm)
+		return err
+	}
+}
+
+type metricsKey struct {
+	apiName   string
+	tableName string
+}
+
+type requestSeries struct {
+	requests      int64
+	errors        map[string]int64
+	buckets       []int64
+	latencySum    time.Duration
+	bytesSent     int64
+	bytesReceived int64
+}
+
+type capacitySeries struct {
+	read  int64
+	write int64
+}
+
+// MetricsSnapshot 为某个API和表的累计指标
+type MetricsSnapshot struct {
+	APIName   string
+	TableName string
+	Requests  int64
+	// Errors 为各错误码出现的次数
+	Errors map[string]int64
+	// LatencyBuckets 为直方图的桶上界，LatencyCounts[i]为延迟不超过LatencyBuckets[i]的请求数，
+	// 最后一个元素为全部请求数
+	LatencyBuckets []float64
+	LatencyCounts  []int64
+	LatencySum     time.Duration
+	BytesSent      int64
+	BytesReceived  int64
+	// ReadCU 和 WriteCU 为该表在该API上消耗的读写能力单元之和
+	ReadCU  int64
+	WriteCU int64
+}
+
+// MemoryMetrics 在内存中累计指标，同时是输出Prometheus文本格式的http.Handler
+type MemoryMetrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	requests map[metricsKey]*requestSeries
+	capacity map[metricsKey]*capacitySeries
+}
+
+// NewMemoryMetrics 创建MemoryMetrics，buckets为延迟直方图的桶上界（秒），为空时使用DefaultLatencyBuckets
+func NewMemoryMetrics(buckets ...float64) *MemoryMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &MemoryMetrics{
+		buckets:  buckets,
+		requests: make(map[metricsKey]*requestSeries),
+		capacity: make(map[metricsKey]*capacitySeries),
+	}
+}
+
+// ObserveRequest 累计一次请求尝试的指标。批量请求在涉及的每张表上各记录一次，
+// 因此跨表汇总时批量请求的次数、延迟和字节数会被重复计算
+func (m *MemoryMetrics) ObserveRequest(rm *RequestMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tableNames := rm.TableNames
+	if len(tableNames) == 0 {
+		tableNames = []string{""}
+	}
+	for _, tableName := range tableNames {
+		m.observe(metricsKey{apiName: rm.APIName, tableName: tableName}, rm)
+	}
+
+	for tableName, cu := range rm.Consumed {
+		key := metricsKey{apiName: rm.APIName, tableName: tableName}
+		capacity, ok := m.capacity[key]
+		if !ok {
+			capacity = &capacitySeries{}
+			m.capacity[key] = capacity
+		}
+		capacity.read += int64(cu.Read)
+		capacity.write += int64(cu.Write)
+	}
+}
+
+// observe 将一次请求尝试累计到key对应的序列，调用者需要持有m.mu
+func (m *MemoryMetrics) observe(key metricsKey, rm *RequestMetrics) {
+	series, ok := m.requests[key]
+	if !ok {
+		series = &requestSeries{
+			errors:  make(map[string]int64),
+			buckets: make([]int64, len(m.buckets)),
+		}
+		m.requests[key] = series
+	}
+	series.requests++
+	if rm.ErrorCode != "" {
+		series.errors[rm.ErrorCode]++
+	}
+	seconds := rm.Duration.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			series.buckets[i]++
+		}
+	}
+	series.latencySum += rm.Duration
+	series.bytesSent += rm.BytesSent
+	series.bytesReceived += rm.BytesReceived
+}
+
+// Snapshot 返回当前累计的指标，按API名和表名排序
+func (m *MemoryMetrics) Snapshot() []*MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshots := make(map[metricsKey]*MetricsSnapshot)
+	get := func(key metricsKey) *MetricsSnapshot {
+		snapshot, ok := snapshots[key]
+		if !ok {
+			snapshot = &MetricsSnapshot{
+				APIName:        key.apiName,
+				TableName:      key.tableName,
+				Errors:         make(map[string]int64),
+				LatencyBuckets: m.buckets,
+				LatencyCounts:  make([]int64, len(m.buckets)+1),
+			}
+			snapshots[key] = snapshot
+		}
+		return snapshot
+	}
+	for key, series := range m.requests {
+		snapshot := get(key)
+		snapshot.Requests = series.requests
+		for code, count := range series.errors {
+			snapshot.Errors[code] = count
+		}
+		copy(snapshot.LatencyCounts, series.buckets)
+		snapshot.LatencyCounts[len(m.buckets)] = series.requests
+		snapshot.LatencySum = series.latencySum
+		snapshot.BytesSent = series.bytesSent
+		snapshot.BytesReceived = series.bytesReceived
+	}
+	for key, capacity := range m.capacity {
+		snapshot := get(key)
+		snapshot.ReadCU = capacity.read
+		snapshot.WriteCU = capacity.write
+	}
+
+	result := make([]*MetricsSnapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		result = append(result, snapshot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].APIName != result[j].APIName {
+			return result[i].APIName < result[j].APIName
+		}
+		return result[i].TableName < result[j].TableName
+	})
+	return result
+}
+
+// Reset 清空累计的指标
+func (m *MemoryMetrics) Reset() {
+	m.mu.Lock()
+	m.requests = make(map[metricsKey]*requestSeries)
+	m.capacity = make(map[metricsKey]*capacitySeries)
+	m.mu.Unlock()
+}
+
+// ServeHTTP 以Prometheus文本格式输出指标
+func (m *MemoryMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	snapshots := m.Snapshot()
+	family := func(name, typ, help string, write func(s *MetricsSnapshot)) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, s := range snapshots {
+			write(s)
+		}
+	}
+	labels := func(s *MetricsSnapshot, extra ...string) string {
+		pairs := append([]string{"api", s.APIName, "table", s.TableName}, extra...)
+		parts := make([]string, 0, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escapeLabelValue(pairs[i+1])))
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	}
+
+	family("ots_requests_total", "counter", "Total number of OTS request attempts.", func(s *MetricsSnapshot) {
+		if s.Requests > 0 {
+			fmt.Fprintf(bw, "ots_requests_total%s %d\n", labels(s), s.Requests)
+		}
+	})
+	family("ots_errors_total", "counter", "Total number of failed OTS request attempts by error code.", func(s *MetricsSnapshot) {
+		codes := make([]string, 0, len(s.Errors))
+		for code := range s.Errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(bw, "ots_errors_total%s %d\n", labels(s, "code", code), s.Errors[code])
+		}
+	})
+	family("ots_request_duration_seconds", "histogram", "Latency of OTS request attempts.", func(s *MetricsSnapshot) {
+		if s.Requests == 0 {
+			return
+		}
+		for i, bound := range s.LatencyBuckets {
+			fmt.Fprintf(bw, "ots_request_duration_seconds_bucket%s %d\n", labels(s, "le", strconv.FormatFloat(bound, 'g', -1, 64)), s.LatencyCounts[i])
+		}
+		fmt.Fprintf(bw, "ots_request_duration_seconds_bucket%s %d\n", labels(s, "le", "+Inf"), s.Requests)
+		fmt.Fprintf(bw, "ots_request_duration_seconds_sum%s %s\n", labels(s), strconv.FormatFloat(s.LatencySum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "ots_request_duration_seconds_count%s %d\n", labels(s), s.Requests)
+	})
+	family("ots_sent_bytes_total", "counter", "Total bytes of OTS request bodies.", func(s *MetricsSnapshot) {
+		if s.Requests > 0 {
+			fmt.Fprintf(bw, "ots_sent_bytes_total%s %d\n", labels(s), s.BytesSent)
+		}
+	})
+	family("ots_received_bytes_total", "counter", "Total bytes of OTS response bodies.", func(s *MetricsSnapshot) {
+		if s.Requests > 0 {
+			fmt.Fprintf(bw, "ots_received_bytes_total%s %d\n", labels(s), s.BytesReceived)
+		}
+	})
+	family("ots_consumed_read_cu_total", "counter", "Total read capacity units consumed.", func(s *MetricsSnapshot) {
+		if s.ReadCU > 0 || s.WriteCU > 0 {
+			fmt.Fprintf(bw, "ots_consumed_read_cu_total%s %d\n", labels(s), s.ReadCU)
+		}
+	})
+	family("ots_consumed_write_cu_total", "counter", "Total write capacity units consumed.", func(s *MetricsSnapshot) {
+		if s.ReadCU > 0 || s.WriteCU > 0 {
+			fmt.Fprintf(bw, "ots_consumed_write_cu_total%s %d\n", labels(s), s.WriteCU)
+		}
+	})
+}
+
+// escapeLabelValue 按Prometheus文本格式转义标签值
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestMetricsInterceptorPerTable(t *testing.T) {
+	m := NewMemoryMetrics(0.1, 1)
+	inv := &Invocation{
+		APIName: "BatchWriteRow",
+		Request: &protobuf.BatchWriteRowRequest{Tables: []*protobuf.TableInBatchWriteRowRequest{
+			{TableName: proto.String("b")},
+			{TableName: proto.String("a")},
+		}},
+	}
+	next := func(ctx context.Context, inv *Invocation) error {
+		inv.Duration = 50 * time.Millisecond
+		inv.Response = &protobuf.BatchWriteRowResponse{Tables: []*protobuf.TableInBatchWriteRowResponse{
+			{TableName: proto.String("a"), PutRows: []*protobuf.RowInBatchWriteRowResponse{
+				{IsOk: proto.Bool(true), Consumed: &protobuf.ConsumedCapacity{CapacityUnit: &protobuf.CapacityUnit{Write: proto.Int32(2)}}},
+			}},
+			{TableName: proto.String("b"), PutRows: []*protobuf.RowInBatchWriteRowResponse{
+				{IsOk: proto.Bool(true), Consumed: &protobuf.ConsumedCapacity{CapacityUnit: &protobuf.CapacityUnit{Write: proto.Int32(3)}}},
+			}},
+		}}
+		return nil
+	}
+	if err := MetricsInterceptor(m)(context.Background(), inv, next); err != nil {
+		t.Fatal(err)
+	}
+	failing := &Invocation{APIName: "ListTable", Request: &protobuf.ListTableRequest{}}
+	MetricsInterceptor(m)(context.Background(), failing, func(ctx context.Context, inv *Invocation) error {
+		return &OTSServiceError{Status: 503, Code: ErrorCodeServerBusy}
+	})
+
+	snapshots := m.Snapshot()
+	if len(snapshots) != 3 {
+		t.Fatalf("Snapshot() has %d series, want 3", len(snapshots))
+	}
+	for i, want := range []struct {
+		api, table string
+		write      int64
+	}{{"BatchWriteRow", "a", 2}, {"BatchWriteRow", "b", 3}, {"ListTable", "", 0}} {
+		s := snapshots[i]
+		if s.APIName != want.api || s.TableName != want.table || s.Requests != 1 || s.WriteCU != want.write {
+			t.Errorf("snapshot %d = %+v, want %s/%s with 1 request and %d write CU", i, s, want.api, want.table, want.write)
+		}
+	}
+	if snapshots[0].LatencyCounts[0] != 1 {
+		t.Errorf("latency counts = %v, want the 0.1s bucket", snapshots[0].LatencyCounts)
+	}
+	if snapshots[2].Errors[ErrorCodeServerBusy] != 1 {
+		t.Errorf("errors = %v, want one %s", snapshots[2].Errors, ErrorCodeServerBusy)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`ots_requests_total{api="BatchWriteRow",table="a"} 1`,
+		`ots_requests_total{api="BatchWriteRow",table="b"} 1`,
+		`ots_consumed_write_cu_total{api="BatchWriteRow",table="b"} 3`,
+		`ots_errors_total{api="ListTable",table="",code="OTSServerBusy"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics output is missing %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `table="a,b"`) {
+		t.Errorf("metrics output has a joined table label:\n%s", body)
+	}
+}
@@ -18,14 +18,19 @@ package gots
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Xuyuanp/gots/protobuf"
@@ -56,10 +61,23 @@ const (
 	HeaderOTSSignature    = "x-ots-signature"
 	HeaderOTSRequestID    = "x-ots-requestid"
 	HeaderOTSContentType  = "x-ots-contenttype"
+	HeaderOTSSTSToken     = "x-ots-ststoken"
 
 	DefaultAPIVersion = "2014-08-08"
 
+	// DefaultContentType 为响应中x-ots-contenttype的默认值
+	DefaultContentType = "protocol buffer"
+
 	TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	// MaxRequestBodySize 为VerifyRequest接受的最大请求大小
+	MaxRequestBodySize = 5 * 1024 * 1024
+
+	// DefaultMaxDateSkew 为x-ots-date与本地时间默认允许的最大差值
+	DefaultMaxDateSkew = 15 * time.Minute
+
+	// clockSkewTolerance 为更新时钟偏差的最小变化，x-ots-date只精确到秒
+	clockSkewTolerance = 2 * time.Second
 )
 
 type Protocol struct {
@@ -67,6 +85,76 @@ type Protocol struct {
 	AccessID     string
 	AccessKey    string
 	InstanceName string
+	// Credentials 不为nil时，每次签名请求前从中获取凭证，AccessID和AccessKey仅用于服务端校验和签名
+	Credentials CredentialsProvider
+	// MaxDateSkew 为x-ots-date与本地时间允许的最大差值，两个方向都会检查。
+	// 为0时使用DefaultMaxDateSkew，小于0时不检查
+	MaxDateSkew time.Duration
+	// ClockSkewCompensation 为true时根据响应的x-ots-date记录本地时钟的偏差，并在签名请求时校正
+	ClockSkewCompensation bool
+
+	// clockOffset 为服务端时间减去本地时间，单位为纳秒
+	clockOffset int64
+}
+
+func (p *Protocol) maxDateSkew() time.Duration {
+	if p.MaxDateSkew == 0 {
+		return DefaultMaxDateSkew
+	}
+	return p.MaxDateSkew
+}
+
+// ClockOffset 返回记录的本地时钟偏差，即服务端时间减去本地时间
+func (p *Protocol) ClockOffset() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.clockOffset))
+}
+
+// SetClockOffset 设置时钟偏差，ClockSkewCompensation为true时生效，可用于恢复之前记录的偏差
+func (p *Protocol) SetClockOffset(offset time.Duration) {
+	atomic.StoreInt64(&p.clockOffset, int64(offset))
+}
+
+// now 返回校正后的当前时间
+func (p *Protocol) now() time.Time {
+	return p.serverTime(time.Now())
+}
+
+// serverTime 按记录的时钟偏差将本地时间t换算为服务端时间
+func (p *Protocol) serverTime(t time.Time) time.Time {
+	if !p.ClockSkewCompensation {
+		return t
+	}
+	return t.Add(p.ClockOffset())
+}
+
+// observeOffset 更新时钟偏差，x-ots-date只精确到秒，变化不超过clockSkewTolerance时不更新。
+// offset只能来自签名校验通过的响应，否则伪造的x-ots-date会影响之后所有请求的签名时间
+func (p *Protocol) observeOffset(offset time.Duration) {
+	if !p.ClockSkewCompensation {
+		return
+	}
+	if diff := offset - p.ClockOffset(); diff > clockSkewTolerance || diff < -clockSkewTolerance {
+		p.SetClockOffset(offset)
+	}
+}
+
+// credentials 返回签名当前请求使用的凭证
+func (p *Protocol) credentials(ctx context.Context) (*Credentials, error) {
+	if p.Credentials == nil {
+		return p.staticCredentials(), nil
+	}
+	creds, err := p.Credentials.Credentials(ctx)
+	if err != nil {
+		return nil, &OTSClientError{Message: fmt.Sprintf("%s Retrieve credentials failed", err.Error()), Err: err}
+	}
+	return creds, nil
+}
+
+func (p *Protocol) staticCredentials() *Credentials {
+	return &Credentials{
+		AccessID:  p.AccessID,
+		AccessKey: p.AccessKey,
+	}
 }
 
 func (p *Protocol) headerString(headers map[string]string) string {
@@ -85,58 +173,76 @@ func (p *Protocol) headerString(headers map[string]string) string {
 	return headerString
 }
 
-func (p *Protocol) calculateSignature(signatureString string) string {
-	h := hmac.New(sha1.New, []byte(p.AccessKey))
+func (p *Protocol) calculateSignature(accessKey, signatureString string) string {
+	h := hmac.New(sha1.New, []byte(accessKey))
 	h.Write([]byte(signatureString))
 	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
 	return signature
 }
 
-func (p *Protocol) makeSignature(query string, headers map[string]string) string {
+func (p *Protocol) makeSignature(accessKey, query string, headers map[string]string) string {
 	stringToSign := query + "\n" + "POST" + "\n\n" + p.headerString(headers) + "\n"
-	signature := p.calculateSignature(stringToSign)
+	signature := p.calculateSignature(accessKey, stringToSign)
 	return signature
 }
 
-func (p *Protocol) makeHeaders(query string, body []byte) map[string]string {
-	m := md5.Sum(body)
-	basemd5 := base64.StdEncoding.EncodeToString(m[:])
-	date := time.Now().UTC().Format(TimeFormat)
+func (p *Protocol) makeHeaders(creds *Credentials, query string, body []byte) map[string]string {
+	basemd5 := contentMD5(body)
+	date := p.now().UTC().Format(TimeFormat)
 
 	headers := map[string]string{
 		HeaderOTSDate:         date,
 		HeaderOTSAPIVersion:   DefaultAPIVersion,
 		HeaderOTSInstanceName: p.InstanceName,
 		HeaderOTSContentMd5:   basemd5,
-		HeaderOTSAccessKeyID:  p.AccessID,
+		HeaderOTSAccessKeyID:  creds.AccessID,
+	}
+	if creds.SecurityToken != "" {
+		headers[HeaderOTSSTSToken] = creds.SecurityToken
 	}
 
-	signature := p.makeSignature(query, headers)
+	signature := p.makeSignature(creds.AccessKey, query, headers)
 	headers[HeaderOTSSignature] = signature
 
 	return headers
 }
 
 func (p *Protocol) MakeRequest(apiName string, body []byte) (*http.Request, error) {
+	return p.MakeRequestWithContext(context.Background(), apiName, body)
+}
+
+// MakeRequestWithContext 同MakeRequest，ctx会被关联到生成的http.Request上
+func (p *Protocol) MakeRequestWithContext(ctx context.Context, apiName string, body []byte) (*http.Request, error) {
+	request, _, err := p.makeRequest(ctx, apiName, body)
+	return request, err
+}
+
+// makeRequest 生成签名后的请求，并返回签名使用的凭证，用于校验响应
+func (p *Protocol) makeRequest(ctx context.Context, apiName string, body []byte) (*http.Request, *Credentials, error) {
 	if _, ok := AllowedAPI[apiName]; !ok {
-		return nil, &OTSClientError{Message: fmt.Sprintf("API %s is not supported", apiName)}
+		return nil, nil, &OTSClientError{Message: fmt.Sprintf("API %s is not supported", apiName)}
+	}
+	creds, err := p.credentials(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 	query := "/" + apiName
-	headers := p.makeHeaders(query, body)
+	headers := p.makeHeaders(creds, query, body)
 
 	rd := bytes.NewReader(body)
-	request, err := http.NewRequest("POST", p.EndPoint+query, rd)
+	request, err := http.NewRequestWithContext(ctx, "POST", p.EndPoint+query, rd)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for k, v := range headers {
 		request.Header.Set(k, v)
 	}
-	return request, nil
+	return request, creds, nil
 }
 
-func (p *Protocol) checkHeaders(headers map[string]string, body []byte) error {
+// checkHeaders 检查响应头，并返回x-ots-date与本地时间的差值，由调用方在校验签名后记录
+func (p *Protocol) checkHeaders(headers map[string]string, body []byte) (time.Duration, error) {
 	headerNames := []string{
 		HeaderOTSDate,
 		HeaderOTSContentMd5,
@@ -145,40 +251,42 @@ func (p *Protocol) checkHeaders(headers map[string]string, body []byte) error {
 	}
 	for _, name := range headerNames {
 		if _, ok := headers[name]; !ok {
-			return &OTSClientError{Message: fmt.Sprintf(`"%s" is missing in response header`, name)}
+			return 0, &OTSClientError{Message: fmt.Sprintf(`"%s" is missing in response header`, name)}
 		}
 	}
 
-	m := md5.Sum(body)
-	basemd5 := base64.StdEncoding.EncodeToString(m[:])
-
-	if bm, _ := headers[HeaderOTSContentMd5]; bm != basemd5 {
-		return &OTSClientError{Message: "MD5 mismatch in response"}
+	if bm, _ := headers[HeaderOTSContentMd5]; bm != contentMD5(body) {
+		return 0, &OTSClientError{Message: "MD5 mismatch in response"}
 	}
 
 	date, _ := headers[HeaderOTSDate]
 	serverTime, err := time.Parse(TimeFormat, date)
 	if err != nil {
-		return &OTSClientError{Message: "Invalid date format in response"}
+		return 0, &OTSClientError{Message: "Invalid date format in response"}
 	}
 
-	now := time.Now()
-	dur := now.Sub(serverTime)
-	if dur > 15*time.Minute {
-		return &OTSClientError{Message: "The difference between date in response and system time is more than 15 minutes"}
+	offset := serverTime.Sub(time.Now())
+	localTime := p.now()
+	maxSkew := p.maxDateSkew()
+	if skew := serverTime.Sub(localTime); maxSkew > 0 && (skew > maxSkew || skew < -maxSkew) {
+		return offset, &ClockSkewError{
+			ServerTime: serverTime,
+			LocalTime:  localTime,
+			MaxSkew:    maxSkew,
+		}
 	}
 
-	return nil
+	return offset, nil
 }
 
-func (p *Protocol) makeResponseSignature(query string, headers map[string]string) string {
+func (p *Protocol) makeResponseSignature(accessKey, query string, headers map[string]string) string {
 	headerString := p.headerString(headers)
 	signatureString := headerString + "\n" + query
-	signature := p.calculateSignature(signatureString)
+	signature := p.calculateSignature(accessKey, signatureString)
 	return signature
 }
 
-func (p *Protocol) checkAuthorization(query string, headers map[string]string) error {
+func (p *Protocol) checkAuthorization(creds *Credentials, query string, headers map[string]string) error {
 	auth, ok := headers["authorization"]
 	if !ok {
 		return &OTSClientError{Message: `"Authorization" is missing in response header`}
@@ -192,30 +300,140 @@ func (p *Protocol) checkAuthorization(query string, headers map[string]string) e
 	}
 	accessid := auths[0]
 	signature := auths[1]
-	if accessid != p.AccessID {
+	if accessid != creds.AccessID {
 		return &OTSClientError{Message: `Invalid AccessID in response`}
 	}
-	if signature != p.makeResponseSignature(query, headers) {
+	if signature != p.makeResponseSignature(creds.AccessKey, query, headers) {
 		return &OTSClientError{Message: `Invalid signature in response`}
 	}
 	return nil
 }
 
+// lowerHeaders 将HTTP头转换为以小写名称为键的映射
+func lowerHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for k := range header {
+		headers[strings.ToLower(k)] = header.Get(k)
+	}
+	return headers
+}
+
+func contentMD5(body []byte) string {
+	m := md5.Sum(body)
+	return base64.StdEncoding.EncodeToString(m[:])
+}
+
+// VerifyRequest 用于服务端校验请求，检查请求头是否完整、AccessID和InstanceName是否匹配、
+// x-ots-date与本地时间的差值、内容MD5以及x-ots-signature签名。
+// 校验失败时返回的OTSServiceError可直接作为错误响应返回给客户端。
+// 请求体被读取后会被重置，调用方仍然可以再次读取
+func (p *Protocol) VerifyRequest(r *http.Request) error {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxRequestBodySize+1))
+	if err != nil {
+		return &OTSServiceError{Status: http.StatusBadRequest, Code: ErrorCodeParameterInvalid, Message: "Read request body failed"}
+	}
+	r.Body.Close()
+	if len(body) > MaxRequestBodySize {
+		return &OTSServiceError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodeRequestBodyTooLarge, Message: fmt.Sprintf("Request body is larger than %d bytes", MaxRequestBodySize)}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	headers := lowerHeaders(r.Header)
+	headerNames := []string{
+		HeaderOTSDate,
+		HeaderOTSAPIVersion,
+		HeaderOTSAccessKeyID,
+		HeaderOTSInstanceName,
+		HeaderOTSContentMd5,
+		HeaderOTSSignature,
+	}
+	for _, name := range headerNames {
+		if _, ok := headers[name]; !ok {
+			return &OTSServiceError{Status: http.StatusForbidden, Code: ErrorCodeAuthFailed, Message: fmt.Sprintf(`"%s" is missing in request header`, name)}
+		}
+	}
+
+	if headers[HeaderOTSAccessKeyID] != p.AccessID {
+		return &OTSServiceError{Status: http.StatusForbidden, Code: ErrorCodeAuthFailed, Message: "Invalid AccessID in request"}
+	}
+	if headers[HeaderOTSInstanceName] != p.InstanceName {
+		return &OTSServiceError{Status: http.StatusForbidden, Code: ErrorCodeAuthFailed, Message: "Invalid InstanceName in request"}
+	}
+
+	clientTime, err := time.Parse(TimeFormat, headers[HeaderOTSDate])
+	if err != nil {
+		return &OTSServiceError{Status: http.StatusForbidden, Code: ErrorCodeAuthFailed, Message: "Invalid date format in request"}
+	}
+	if maxSkew, skew := p.maxDateSkew(), p.now().Sub(clientTime); maxSkew > 0 && (skew > maxSkew || skew < -maxSkew) {
+		return &OTSServiceError{Status: http.StatusForbidden, Code: ErrorCodeAuthFailed, Message: fmt.Sprintf("The difference between date in request and system time is more than %v", maxSkew)}
+	}
+
+	if headers[HeaderOTSContentMd5] != contentMD5(body) {
+		return &OTSServiceError{Status: http.StatusBadRequest, Code: ErrorCodeParameterInvalid, Message: "MD5 mismatch in request"}
+	}
+
+	signature := p.makeSignature(p.AccessKey, r.URL.Path, headers)
+	if !hmac.Equal([]byte(headers[HeaderOTSSignature]), []byte(signature)) {
+		return &OTSServiceError{Status: http.StatusForbidden, Code: ErrorCodeAuthFailed, Message: "Signature mismatch in request"}
+	}
+	return nil
+}
+
+// SignResponse 用于服务端签名响应，设置x-ots-date、x-ots-contentmd5以及Authorization头，
+// x-ots-contenttype未设置时使用DefaultContentType。x-ots-requestid需要在调用前设置
+func (p *Protocol) SignResponse(apiName string, header http.Header, body []byte) {
+	header.Set(HeaderOTSDate, p.now().UTC().Format(TimeFormat))
+	header.Set(HeaderOTSContentMd5, contentMD5(body))
+	if header.Get(HeaderOTSContentType) == "" {
+		header.Set(HeaderOTSContentType, DefaultContentType)
+	}
+	signature := p.makeResponseSignature(p.AccessKey, "/"+apiName, lowerHeaders(header))
+	header.Set("Authorization", fmt.Sprintf("OTS %s:%s", p.AccessID, signature))
+}
+
 func (p *Protocol) ParseResponse(apiName string, status int, headers map[string]string, data []byte) error {
+	return p.parseResponse(p.staticCredentials(), apiName, status, headers, data)
+}
+
+// parseResponse 校验响应并解析错误，creds为签名对应请求时使用的凭证
+func (p *Protocol) parseResponse(creds *Credentials, apiName string, status int, headers map[string]string, data []byte) error {
 	if _, ok := AllowedAPI[apiName]; !ok {
 		return &OTSClientError{Message: fmt.Sprintf("API %s is not supported", apiName)}
 	}
 
 	query := "/" + apiName
 
-	if err := p.checkHeaders(headers, data); err != nil {
+	offset, err := p.checkHeaders(headers, data)
+	if err != nil {
+		var skewErr *ClockSkewError
+		if !errors.As(err, &skewErr) {
+			return err
+		}
+		// 只有签名正确的响应才能用于校正时钟，否则重试仍会使用未校正的时间签名
+		if p.checkAuthorization(creds, query, headers) == nil {
+			p.observeOffset(offset)
+			skewErr.Compensated = p.ClockSkewCompensation
+		}
+		// 服务端因时间差拒绝请求时，响应时间同样超出范围，此时请求没有被执行
+		if status == http.StatusForbidden {
+			pbError := &protobuf.Error{}
+			if proto.Unmarshal(data, pbError) == nil && pbError.GetCode() == ErrorCodeAuthFailed {
+				skewErr.Err = &OTSServiceError{
+					Status:    status,
+					Code:      pbError.GetCode(),
+					Message:   pbError.GetMessage(),
+					RequestID: headers[HeaderOTSRequestID],
+				}
+			}
+		}
 		return err
 	}
 
 	if status != 403 {
-		if err := p.checkAuthorization(query, headers); err != nil {
+		if err := p.checkAuthorization(creds, query, headers); err != nil {
 			return &OTSClientError{Message: fmt.Sprintf("%s HTTP status: %d", err.Error(), status)}
 		}
+		p.observeOffset(offset)
 	}
 
 	if status >= 200 && status < 300 {
@@ -225,17 +443,18 @@ func (p *Protocol) ParseResponse(apiName string, status int, headers map[string]
 	requestID, _ := headers[HeaderOTSRequestID]
 	pbError := &protobuf.Error{}
 	if err := proto.Unmarshal(data, pbError); err != nil {
-		return &OTSClientError{Status: status, Message: fmt.Sprintf("HTTP status: %s", status)}
+		return &OTSClientError{Status: status, Message: fmt.Sprintf("HTTP status: %d", status)}
 	}
 
 	errorCode := pbError.GetCode()
 	errorMessage := pbError.GetMessage()
 
-	if status == 403 && errorCode != "OTSAuthFailed" {
-		authError := p.checkAuthorization(query, headers)
+	if status == 403 && errorCode != ErrorCodeAuthFailed {
+		authError := p.checkAuthorization(creds, query, headers)
 		if authError != nil {
 			return &OTSClientError{Status: status, Message: fmt.Sprintf("%s HTTP status: %d", authError.Error(), status)}
 		}
+		p.observeOffset(offset)
 	}
 
 	return &OTSServiceError{
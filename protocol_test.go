@@ -0,0 +1,167 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func newTestProtocol() *Protocol {
+	return &Protocol{
+		EndPoint:     "http://127.0.0.1",
+		AccessID:     "access-id",
+		AccessKey:    "access-key",
+		InstanceName: "instance",
+	}
+}
+
+func verifyError(t *testing.T, err error) *OTSServiceError {
+	t.Helper()
+	var serviceErr *OTSServiceError
+	if !errors.As(err, &serviceErr) {
+		t.Fatalf("VerifyRequest() = %v, want *OTSServiceError", err)
+	}
+	return serviceErr
+}
+
+func TestVerifyRequest(t *testing.T) {
+	p := newTestProtocol()
+	body := []byte("request body")
+	r, err := p.MakeRequest("PutRow", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.VerifyRequest(r); err != nil {
+		t.Fatalf("VerifyRequest() = %v", err)
+	}
+	got, err := ioutil.ReadAll(r.Body)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("body after VerifyRequest = %q, %v, want %q", got, err, body)
+	}
+}
+
+func TestVerifyRequestSignatureMismatch(t *testing.T) {
+	p := newTestProtocol()
+	r, err := p.MakeRequest("PutRow", []byte("request body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := []byte(r.Header.Get(HeaderOTSSignature))
+	signature[0] ^= 1
+	r.Header.Set(HeaderOTSSignature, string(signature))
+
+	serviceErr := verifyError(t, p.VerifyRequest(r))
+	if serviceErr.Status != http.StatusForbidden || serviceErr.Code != ErrorCodeAuthFailed {
+		t.Fatalf("VerifyRequest() = %v, want %s", serviceErr, ErrorCodeAuthFailed)
+	}
+}
+
+func TestVerifyRequestBodyTooLarge(t *testing.T) {
+	p := newTestProtocol()
+	r, err := p.MakeRequest("PutRow", make([]byte, MaxRequestBodySize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceErr := verifyError(t, p.VerifyRequest(r))
+	if serviceErr.Status != http.StatusRequestEntityTooLarge || serviceErr.Code != ErrorCodeRequestBodyTooLarge {
+		t.Fatalf("VerifyRequest() = %v, want %s", serviceErr, ErrorCodeRequestBodyTooLarge)
+	}
+}
+
+// signedResponse 返回服务端时钟比本地快offset、使用accessKey签名的响应头
+func signedResponse(accessKey string, offset time.Duration, body []byte) map[string]string {
+	server := newTestProtocol()
+	server.AccessKey = accessKey
+	server.ClockSkewCompensation = true
+	server.SetClockOffset(offset)
+	header := http.Header{}
+	header.Set(HeaderOTSRequestID, "request-id")
+	server.SignResponse("ListTable", header, body)
+	return lowerHeaders(header)
+}
+
+func TestParseResponseClockOffset(t *testing.T) {
+	body := []byte("response body")
+	tests := []struct {
+		name      string
+		accessKey string
+		offset    time.Duration
+		skewed    bool
+		want      time.Duration
+	}{
+		{"signed", "access-key", 10 * time.Second, false, 10 * time.Second},
+		{"signed skewed", "access-key", time.Hour, true, time.Hour},
+		{"forged", "forged-key", 10 * time.Second, false, 0},
+		{"forged skewed", "forged-key", time.Hour, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProtocol()
+			p.ClockSkewCompensation = true
+			err := p.ParseResponse("ListTable", http.StatusOK, signedResponse(tt.accessKey, tt.offset, body), body)
+			if IsClockSkew(err) != tt.skewed {
+				t.Errorf("ParseResponse() = %v, want clock skew %v", err, tt.skewed)
+			}
+			if got := p.ClockOffset(); got < tt.want-clockSkewTolerance || got > tt.want+clockSkewTolerance {
+				t.Errorf("ClockOffset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResponseClockSkewCompensated(t *testing.T) {
+	authFailed, _ := proto.Marshal(&protobuf.Error{Code: proto.String(ErrorCodeAuthFailed)})
+	unsigned := signedResponse("access-key", time.Hour, authFailed)
+	delete(unsigned, "authorization")
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		compensated bool
+	}{
+		{"signed", signedResponse("access-key", time.Hour, authFailed), true},
+		{"forged", signedResponse("forged-key", time.Hour, authFailed), false},
+		{"unsigned", unsigned, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProtocol()
+			p.ClockSkewCompensation = true
+			err := p.ParseResponse("ListTable", http.StatusForbidden, tt.headers, authFailed)
+			var skewErr *ClockSkewError
+			if !errors.As(err, &skewErr) {
+				t.Fatalf("ParseResponse() = %v, want clock skew", err)
+			}
+			if skewErr.Compensated != tt.compensated {
+				t.Errorf("Compensated = %v, want %v", skewErr.Compensated, tt.compensated)
+			}
+			if got := shouldRetry("PutRow", err); got != tt.compensated {
+				t.Errorf("shouldRetry() = %v, want %v", got, tt.compensated)
+			}
+			if got := shouldRetry("ListTable", err); got != tt.compensated {
+				t.Errorf("shouldRetry() idempotent = %v, want %v", got, tt.compensated)
+			}
+		})
+	}
+}
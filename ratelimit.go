@@ -0,0 +1,421 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// DefaultLimiterBurst 为令牌桶默认可以积累的时长，即最多积累1秒的预留能力单元
+	DefaultLimiterBurst = time.Second
+	// capacityUnitSize 为一个能力单元对应的数据大小
+	capacityUnitSize = 4 * 1024
+	// seedRetryInterval 为DescribeTable获取预留能力单元失败后到下一次重试的间隔
+	seedRetryInterval = 30 * time.Second
+)
+
+// RateLimitError 表示在context的截止时间之前无法获得足够的能力单元或请求配额，请求没有被发送
+type RateLimitError struct {
+	TableName string
+	// Wait 为获得能力单元或请求配额需要等待的时间
+	Wait time.Duration
+	// Adaptive 为true时表示由AdaptiveLimiter的请求速率限制，否则为CapacityLimiter的能力单元限制
+	Adaptive bool
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Adaptive {
+		return fmt.Sprintf("Request rate of table %s is limited before deadline, need to wait %v", e.TableName, e.Wait)
+	}
+	return fmt.Sprintf("Capacity units of table %s are not enough before deadline, need to wait %v", e.TableName, e.Wait)
+}
+
+// IsRateLimited 返回err是否为客户端限流导致的错误
+func IsRateLimited(err error) bool {
+	var limitErr *RateLimitError
+	return errors.As(err, &limitErr)
+}
+
+// tokenBucket 为允许欠账的令牌桶，rate为0时不限流
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// setRate 修改令牌桶的速率和容量，新建的令牌桶是满的
+func (b *tokenBucket) setRate(rate, burst float64, now time.Time) {
+	fresh := b.last.IsZero()
+	b.refill(now)
+	b.rate = rate
+	b.burst = burst
+	if fresh || b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && b.rate > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// reserve 返回取走n个令牌需要等待的时间，不修改令牌数。
+// n超过burst时只需等到令牌桶满，之后由take欠账，否则永远无法获得
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 || n <= 0 {
+		return 0
+	}
+	b.refill(now)
+	need := math.Min(n, b.burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take 取走n个令牌，n为负数时归还，令牌数可以为负
+func (b *tokenBucket) take(n float64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens-n)
+}
+
+// tableLimiter 为单张表的读写令牌桶
+type tableLimiter struct {
+	seedMu sync.Mutex
+	seeded bool
+	// seedRetryAt 之前不再重试失败的DescribeTable
+	seedRetryAt time.Time
+
+	reserved CapacityUnit
+	read     tokenBucket
+	write    tokenBucket
+}
+
+// CapacityLimiter 按表的预留读写能力单元在客户端限流。
+// 首次访问某张表时通过DescribeTable获取预留能力单元，发送请求前按编码后的行大小预估消耗并等待令牌，
+// 收到响应后按实际的ConsumedCapacity修正。预留能力单元为0的维度不限流。
+// 需要等待时，若context的截止时间之前无法获得令牌则立即返回RateLimitError，没有截止时间则一直等待。
+// 示例:
+//
+//	client.CapacityLimiter = gots.NewCapacityLimiter()
+type CapacityLimiter struct {
+	// Burst 为令牌桶可以积累的时长，为0时使用DefaultLimiterBurst
+	Burst time.Duration
+
+	mu     sync.Mutex
+	tables map[string]*tableLimiter
+}
+
+// NewCapacityLimiter 返回CapacityLimiter
+func NewCapacityLimiter() *CapacityLimiter {
+	return &CapacityLimiter{}
+}
+
+func (l *CapacityLimiter) burst() time.Duration {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return DefaultLimiterBurst
+}
+
+func (l *CapacityLimiter) table(name string) *tableLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tables == nil {
+		l.tables = make(map[string]*tableLimiter)
+	}
+	t, ok := l.tables[name]
+	if !ok {
+		t = &tableLimiter{}
+		l.tables[name] = t
+	}
+	return t
+}
+
+// SetCapacity 设置表的预留读写能力单元，DescribeTable、CreateTable和UpdateTable成功后会自动调用
+func (l *CapacityLimiter) SetCapacity(tableName string, cu *CapacityUnit) {
+	if cu == nil {
+		return
+	}
+	t := l.table(tableName)
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t.seeded = true
+	t.reserved = *cu
+	burst := l.burst().Seconds()
+	t.read.setRate(float64(cu.Read), float64(cu.Read)*burst, now)
+	t.write.setRate(float64(cu.Write), float64(cu.Write)*burst, now)
+}
+
+// Capacity 返回表的预留读写能力单元，未知时返回nil
+func (l *CapacityLimiter) Capacity(tableName string) *CapacityUnit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.tables[tableName]
+	if !ok || !t.seeded {
+		return nil
+	}
+	cu := t.reserved
+	return &cu
+}
+
+// Remove 删除表的限流状态，DeleteTable成功后会自动调用
+func (l *CapacityLimiter) Remove(tableName string) {
+	l.mu.Lock()
+	delete(l.tables, tableName)
+	l.mu.Unlock()
+}
+
+// seeding 返回是否需要通过DescribeTable获取表的预留能力单元
+func (l *CapacityLimiter) seeding(t *tableLimiter, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !t.seeded && !now.Before(t.seedRetryAt)
+}
+
+// seed 在表的预留能力单元未知时通过DescribeTable获取。
+// 失败时记录日志并且不限流，seedRetryInterval之后再重试
+func (l *CapacityLimiter) seed(ctx context.Context, c *Client, tableName string) {
+	t := l.table(tableName)
+	if !l.seeding(t, time.Now()) {
+		return
+	}
+	t.seedMu.Lock()
+	defer t.seedMu.Unlock()
+	if !l.seeding(t, time.Now()) {
+		return
+	}
+	// DescribeTable成功后会通过SetCapacity设置
+	_, _, err := c.DescribeTableWithContext(ctx, tableName)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	l.mu.Lock()
+	t.seedRetryAt = time.Now().Add(seedRetryInterval)
+	l.mu.Unlock()
+	if c.Logger != nil {
+		c.Logger.Warn("ots capacity limiter: describe table failed, not limiting", "table", tableName, "retry_after", seedRetryInterval, "error", err)
+	}
+}
+
+// Wait 等待直到各表有足够的能力单元并扣除，charge为各表预估消耗的能力单元
+func (l *CapacityLimiter) Wait(ctx context.Context, charge map[string]*CapacityUnit) error {
+	for {
+		now := time.Now()
+		var (
+			wait  time.Duration
+			table string
+		)
+		l.mu.Lock()
+		for name, cu := range charge {
+			t, ok := l.tables[name]
+			if !ok {
+				continue
+			}
+			if d := t.read.reserve(float64(cu.Read), now); d > wait {
+				wait, table = d, name
+			}
+			if d := t.write.reserve(float64(cu.Write), now); d > wait {
+				wait, table = d, name
+			}
+		}
+		if wait == 0 {
+			for name, cu := range charge {
+				if t, ok := l.tables[name]; ok {
+					t.read.take(float64(cu.Read))
+					t.write.take(float64(cu.Write))
+				}
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+			return &RateLimitError{TableName: table, Wait: wait}
+		}
+		if !sleep(ctx, wait) {
+			return &OTSClientError{Message: fmt.Sprintf("%s Wait for capacity units canceled", ctx.Err().Error()), Err: ctx.Err()}
+		}
+	}
+}
+
+// settle 按实际消耗修正预扣的能力单元，actual为nil表示请求没有被执行，归还全部预扣
+func (l *CapacityLimiter) settle(charge, actual map[string]*CapacityUnit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name, cu := range charge {
+		t, ok := l.tables[name]
+		if !ok {
+			continue
+		}
+		var consumed CapacityUnit
+		if c, ok := actual[name]; ok {
+			consumed = *c
+		}
+		t.read.take(float64(consumed.Read - cu.Read))
+		t.write.take(float64(consumed.Write - cu.Write))
+	}
+}
+
+// acquire 在发送数据请求前预扣能力单元，表操作不限流，返回nil表示无需修正
+func (l *CapacityLimiter) acquire(ctx context.Context, c *Client, message proto.Message) (map[string]*CapacityUnit, error) {
+	charge := estimateCapacity(message)
+	if len(charge) == 0 {
+		return nil, nil
+	}
+	for name := range charge {
+		l.seed(ctx, c, name)
+	}
+	if err := l.Wait(ctx, charge); err != nil {
+		return nil, err
+	}
+	return charge, nil
+}
+
+// estimateCapacity 按请求中编码后的行大小预估各表消耗的能力单元，每4KB为一个单元，不足4KB按1个计算。
+// 读请求无法预知返回的数据量，每行按1个读能力单元预估；带行存在性条件的写操作额外消耗1个读能力单元
+func estimateCapacity(message proto.Message) map[string]*CapacityUnit {
+	charge := make(map[string]*CapacityUnit)
+	add := func(tableName string, read, write int32) {
+		cu, ok := charge[tableName]
+		if !ok {
+			cu = &CapacityUnit{}
+			charge[tableName] = cu
+		}
+		cu.Read += read
+		cu.Write += write
+	}
+	conditionRead := func(condition *protobuf.Condition) int32 {
+		if condition.GetRowExistence() != protobuf.RowExistenceExpectation_IGNORE {
+			return 1
+		}
+		return 0
+	}
+
+	switch m := message.(type) {
+	case *protobuf.GetRowRequest:
+		add(m.GetTableName(), 1, 0)
+	case *protobuf.GetRangeRequest:
+		add(m.GetTableName(), 1, 0)
+	case *protobuf.PutRowRequest:
+		add(m.GetTableName(), conditionRead(m.GetCondition()), writeUnits(columnsSize(m.GetPrimaryKey())+columnsSize(m.GetAttributeColumns())))
+	case *protobuf.UpdateRowRequest:
+		add(m.GetTableName(), conditionRead(m.GetCondition()), writeUnits(columnsSize(m.GetPrimaryKey())+columnUpdatesSize(m.GetAttributeColumns())))
+	case *protobuf.DeleteRowRequest:
+		add(m.GetTableName(), conditionRead(m.GetCondition()), writeUnits(columnsSize(m.GetPrimaryKey())))
+	case *protobuf.BatchGetRowRequest:
+		for _, table := range m.GetTables() {
+			add(table.GetTableName(), int32(len(table.GetRows())), 0)
+		}
+	case *protobuf.BatchWriteRowRequest:
+		for _, table := range m.GetTables() {
+			name := table.GetTableName()
+			for _, row := range table.GetPutRows() {
+				add(name, conditionRead(row.GetCondition()), writeUnits(columnsSize(row.GetPrimaryKey())+columnsSize(row.GetAttributeColumns())))
+			}
+			for _, row := range table.GetUpdateRows() {
+				add(name, conditionRead(row.GetCondition()), writeUnits(columnsSize(row.GetPrimaryKey())+columnUpdatesSize(row.GetAttributeColumns())))
+			}
+			for _, row := range table.GetDeleteRows() {
+				add(name, conditionRead(row.GetCondition()), writeUnits(columnsSize(row.GetPrimaryKey())))
+			}
+		}
+	}
+	return charge
+}
+
+func columnsSize(columns []*protobuf.Column) int {
+	size := 0
+	for _, column := range columns {
+		size += proto.Size(column)
+	}
+	return size
+}
+
+func columnUpdatesSize(columns []*protobuf.ColumnUpdate) int {
+	size := 0
+	for _, column := range columns {
+		size += proto.Size(column)
+	}
+	return size
+}
+
+func writeUnits(size int) int32 {
+	if size <= 0 {
+		return 1
+	}
+	return int32((size + capacityUnitSize - 1) / capacityUnitSize)
+}
+
+// limit 在发送请求前等待CapacityLimiter和AdaptiveLimiter，返回的函数在收到响应后调用以修正限流状态
+func (c *Client) limit(ctx context.Context, message proto.Message) (func(*Invocation, error), error) {
+	var (
+		charge     map[string]*CapacityUnit
+		tableNames []string
+		err        error
+	)
+	if c.CapacityLimiter != nil {
+		if charge, err = c.CapacityLimiter.acquire(ctx, c, message); err != nil {
+			return nil, err
+		}
+	}
+	if c.AdaptiveLimiter != nil {
+		tableNames = dataTableNames(message)
+		if err = c.AdaptiveLimiter.Wait(ctx, tableNames); err != nil {
+			if charge != nil {
+				c.CapacityLimiter.settle(charge, nil)
+			}
+			return nil, err
+		}
+	}
+	return func(inv *Invocation, err error) {
+		if charge != nil {
+			// 请求失败但可能已被执行时，服务端同样会消耗能力单元，按预估值扣除
+			actual := charge
+			if err == nil {
+				actual = inv.ConsumedCapacity()
+			} else if notExecuted(inv, err) {
+				actual = nil
+			}
+			c.CapacityLimiter.settle(charge, actual)
+		}
+		if len(tableNames) > 0 && (err == nil || IsThrottled(err)) {
+			c.AdaptiveLimiter.Observe(tableNames, throttledTables(tableNames, inv, err))
+		}
+	}, nil
+}
+
+// notExecuted 判断失败的请求是否确定没有被服务端执行：请求未能构建、被限流或建立链接失败
+func notExecuted(inv *Invocation, err error) bool {
+	return inv == nil || IsThrottled(err) || dialFailed(err)
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestTokenBucketChargeOverBurst(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{}
+	b.setRate(10, 10, now)
+
+	if d := b.reserve(25, now); d != 0 {
+		t.Fatalf("reserve(25) on full bucket = %v, want 0", d)
+	}
+	b.take(25)
+	if b.tokens != -15 {
+		t.Fatalf("tokens after take(25) = %v, want -15", b.tokens)
+	}
+	if d := b.reserve(25, now); d != 2500*time.Millisecond {
+		t.Errorf("reserve(25) in debt = %v, want 2.5s until the bucket is full", d)
+	}
+	if d := b.reserve(25, now.Add(2500*time.Millisecond)); d != 0 {
+		t.Errorf("reserve(25) after refill = %v, want 0", d)
+	}
+}
+
+func TestCapacityLimiterWaitAndSettle(t *testing.T) {
+	l := NewCapacityLimiter()
+	l.SetCapacity("users", &CapacityUnit{Read: 0, Write: 10})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	charge := map[string]*CapacityUnit{"users": {Read: 100, Write: 10}}
+	if err := l.Wait(ctx, charge); err != nil {
+		t.Fatalf("Wait() on full bucket = %v", err)
+	}
+	err := l.Wait(ctx, map[string]*CapacityUnit{"users": {Write: 5}})
+	limitErr, ok := err.(*RateLimitError)
+	if !ok || limitErr.TableName != "users" || limitErr.Wait < 400*time.Millisecond {
+		t.Fatalf("Wait() on empty bucket = %v, want RateLimitError of about 500ms", err)
+	}
+
+	l.settle(charge, map[string]*CapacityUnit{"users": {Write: 2}})
+	if err := l.Wait(ctx, map[string]*CapacityUnit{"users": {Write: 5}}); err != nil {
+		t.Fatalf("Wait() after refund = %v", err)
+	}
+
+	// 请求失败时归还全部预扣
+	l.settle(map[string]*CapacityUnit{"users": {Write: 5}}, nil)
+	if err := l.Wait(ctx, map[string]*CapacityUnit{"users": {Write: 5}}); err != nil {
+		t.Fatalf("Wait() after failed request = %v", err)
+	}
+
+	if err := l.Wait(ctx, map[string]*CapacityUnit{"unknown": {Read: 1000, Write: 1000}}); err != nil {
+		t.Errorf("Wait() on unknown table = %v, want no limit", err)
+	}
+}
+
+func TestCapacityLimiterSettleFailedRequest(t *testing.T) {
+	client := NewClient("http://127.0.0.1:1", "id", "key", "instance")
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	client.CapacityLimiter = NewCapacityLimiter()
+	client.CapacityLimiter.SetCapacity("users", &CapacityUnit{Write: 10})
+	message := &protobuf.PutRowRequest{TableName: proto.String("users")}
+	charge := float64(estimateCapacity(message)["users"].Write)
+	inv := &Invocation{APIName: "PutRow", Request: message, StatusCode: 403}
+
+	tests := []struct {
+		name string
+		inv  *Invocation
+		err  error
+		want float64
+	}{
+		{"condition check failed", inv, &OTSServiceError{Status: 403, Code: ErrorCodeConditionCheckFail}, charge},
+		{"server error", inv, &OTSServiceError{Status: 500, Code: ErrorCodeInternalServerError}, charge},
+		{"throttled", inv, &OTSServiceError{Status: 503, Code: ErrorCodeNotEnoughCapacityUnit}, 0},
+		{"dial failed", inv, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, 0},
+		{"not built", nil, &OTSClientError{Message: "Make request failed"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := client.CapacityLimiter
+			l.mu.Lock()
+			bucket := &l.tables["users"].write
+			bucket.tokens = bucket.burst
+			l.mu.Unlock()
+
+			done, err := client.limit(context.Background(), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done(tt.inv, tt.err)
+			l.mu.Lock()
+			used := bucket.burst - bucket.tokens
+			l.mu.Unlock()
+			if math.Abs(used-tt.want) > 0.5 {
+				t.Errorf("capacity used = %v, want %v", used, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapacityLimiterChargeOverBurst(t *testing.T) {
+	l := NewCapacityLimiter()
+	l.SetCapacity("users", &CapacityUnit{Write: 10})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx, map[string]*CapacityUnit{"users": {Write: 50}}); err != nil {
+		t.Fatalf("Wait() with charge over burst = %v, want granted", err)
+	}
+	if err := l.Wait(ctx, map[string]*CapacityUnit{"users": {Write: 50}}); !IsRateLimited(err) {
+		t.Fatalf("Wait() while in debt = %v, want RateLimitError", err)
+	}
+}
+
+func TestCapacityLimiterSeedFailure(t *testing.T) {
+	client := NewClient("http://127.0.0.1:1", "id", "key", "instance")
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	logger := &recordLogger{}
+	client.Logger = logger
+	describes := 0
+	client.Interceptors = []Interceptor{func(ctx context.Context, inv *Invocation, next Handler) error {
+		describes++
+		return &OTSServiceError{Status: 503, Code: ErrorCodeServerUnavailable}
+	}}
+
+	l := NewCapacityLimiter()
+	for i := 0; i < 3; i++ {
+		l.seed(context.Background(), client, "users")
+	}
+	if describes != 1 {
+		t.Errorf("DescribeTable called %d times, want 1 within the retry interval", describes)
+	}
+	if entries := logger.Entries(); len(entries) != 2 || entries[1] != "WARN ots capacity limiter: describe table failed, not limiting" {
+		t.Errorf("log entries = %q, want a warning for the seed failure", entries)
+	}
+
+	l.mu.Lock()
+	l.tables["users"].seedRetryAt = time.Now()
+	l.mu.Unlock()
+	l.seed(context.Background(), client, "users")
+	if describes != 2 {
+		t.Errorf("DescribeTable called %d times after the retry interval, want 2", describes)
+	}
+}
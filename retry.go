@@ -0,0 +1,174 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts 默认最大尝试次数（包括第一次请求）
+	DefaultMaxAttempts = 4
+	// DefaultInitialInterval 默认第一次重试前的等待时间
+	DefaultInitialInterval = 100 * time.Millisecond
+	// DefaultMaxInterval 默认两次重试之间的最长等待时间
+	DefaultMaxInterval = 5 * time.Second
+	// DefaultMultiplier 默认等待时间的增长倍数
+	DefaultMultiplier = 2.0
+	// DefaultMaxElapsedTime 默认从第一次请求开始允许重试的最长时间
+	DefaultMaxElapsedTime = 30 * time.Second
+)
+
+// IdempotentAPI 中的API重复执行不会改变结果，服务端超时或内部错误时可以安全重试
+var IdempotentAPI = map[string]bool{
+	"ListTable":     true,
+	"DescribeTable": true,
+	"GetRow":        true,
+	"BatchGetRow":   true,
+	"GetRange":      true,
+}
+
+// RetryPolicy 决定请求失败后是否重试以及重试前的等待时间
+type RetryPolicy interface {
+	// ShouldRetry 返回是否重试以及重试前的等待时间。
+	// attempt 为已经尝试的次数，elapsed 为从第一次请求开始经过的时间
+	ShouldRetry(apiName string, attempt int, elapsed time.Duration, err error) (time.Duration, bool)
+}
+
+// NoRetryPolicy 从不重试
+type NoRetryPolicy struct{}
+
+func (NoRetryPolicy) ShouldRetry(apiName string, attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	return 0, false
+}
+
+// DefaultRetryPolicy 对可重试的错误进行带随机抖动的指数退避重试。
+// 零值字段使用对应的默认值，零值的DefaultRetryPolicy与NewDefaultRetryPolicy相同，但不限制重试的总时间
+type DefaultRetryPolicy struct {
+	// MaxAttempts 为最大尝试次数（包括第一次请求），为0时使用DefaultMaxAttempts
+	MaxAttempts int
+	// InitialInterval 为第一次重试前的等待时间，为0时使用DefaultInitialInterval，小于0时不等待
+	InitialInterval time.Duration
+	// MaxInterval 为两次重试之间的最长等待时间，为0时使用DefaultMaxInterval
+	MaxInterval time.Duration
+	// Multiplier 为等待时间的增长倍数，为0时使用DefaultMultiplier
+	Multiplier float64
+	// MaxElapsedTime 为从第一次请求开始允许重试的最长时间，为0时不限制
+	MaxElapsedTime time.Duration
+}
+
+// NewDefaultRetryPolicy 返回使用默认参数的DefaultRetryPolicy
+func NewDefaultRetryPolicy() *DefaultRetryPolicy {
+	return &DefaultRetryPolicy{
+		MaxAttempts:     DefaultMaxAttempts,
+		InitialInterval: DefaultInitialInterval,
+		MaxInterval:     DefaultMaxInterval,
+		Multiplier:      DefaultMultiplier,
+		MaxElapsedTime:  DefaultMaxElapsedTime,
+	}
+}
+
+func (p *DefaultRetryPolicy) ShouldRetry(apiName string, attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	if attempt >= p.maxAttempts() || !shouldRetry(apiName, err) {
+		return 0, false
+	}
+	delay := p.backoff(attempt)
+	if p.MaxElapsedTime > 0 && elapsed+delay > p.MaxElapsedTime {
+		return 0, false
+	}
+	return delay, true
+}
+
+func (p *DefaultRetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (p *DefaultRetryPolicy) initialInterval() time.Duration {
+	switch {
+	case p.InitialInterval > 0:
+		return p.InitialInterval
+	case p.InitialInterval < 0:
+		return 0
+	}
+	return DefaultInitialInterval
+}
+
+func (p *DefaultRetryPolicy) maxInterval() time.Duration {
+	if p.MaxInterval > 0 {
+		return p.MaxInterval
+	}
+	return DefaultMaxInterval
+}
+
+func (p *DefaultRetryPolicy) multiplier() float64 {
+	if p.Multiplier > 0 {
+		return p.Multiplier
+	}
+	return DefaultMultiplier
+}
+
+// backoff 返回第attempt次失败后的等待时间，在[interval/2, interval)之间随机取值
+func (p *DefaultRetryPolicy) backoff(attempt int) time.Duration {
+	interval := float64(p.initialInterval()) * math.Pow(p.multiplier(), float64(attempt-1))
+	if maxInterval := float64(p.maxInterval()); interval > maxInterval {
+		interval = maxInterval
+	}
+	half := interval / 2
+	return time.Duration(half + rand.Float64()*half)
+}
+
+// shouldRetry 根据错误类型和API是否幂等判断请求是否可以重试
+func shouldRetry(apiName string, err error) bool {
+	// 时钟偏差已校正时，被服务端拒绝的请求或幂等的请求可以重新签名后重试
+	var skewErr *ClockSkewError
+	if errors.As(err, &skewErr) {
+		return skewErr.Compensated && (skewErr.Err != nil || IdempotentAPI[apiName])
+	}
+	if !IsRetryable(err) {
+		return false
+	}
+	if IsThrottled(err) || IdempotentAPI[apiName] {
+		return true
+	}
+	return dialFailed(err)
+}
+
+// dialFailed 判断错误是否为建立链接失败，此时请求没有被发送
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// sleep 等待指定的时间，ctx被取消时提前返回false
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
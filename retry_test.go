@@ -0,0 +1,158 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestDefaultRetryPolicyClassification(t *testing.T) {
+	p := NewDefaultRetryPolicy()
+	throttled := &OTSServiceError{Status: 503, Code: ErrorCodeNotEnoughCapacityUnit}
+	internal := &OTSServiceError{Status: 500, Code: ErrorCodeInternalServerError}
+	dial := &OTSClientError{Err: &url.Error{Op: "Post", URL: "http://x", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}}
+	read := &OTSClientError{Err: &url.Error{Op: "Post", URL: "http://x", Err: &net.OpError{Op: "read", Err: errors.New("reset")}}}
+	cases := []struct {
+		api  string
+		err  error
+		want bool
+	}{
+		{"PutRow", throttled, true},
+		{"PutRow", internal, false},
+		{"GetRow", internal, true},
+		{"PutRow", dial, true},
+		{"PutRow", read, false},
+		{"GetRange", read, true},
+		{"GetRow", &OTSServiceError{Status: 403, Code: ErrorCodeConditionCheckFail}, false},
+		{"PutRow", &ClockSkewError{Compensated: true, Err: &OTSServiceError{Status: 403, Code: ErrorCodeAuthFailed}}, true},
+		{"PutRow", &ClockSkewError{Compensated: true}, false},
+		{"GetRow", &ClockSkewError{Compensated: true}, true},
+		{"GetRow", &ClockSkewError{}, false},
+	}
+	for _, c := range cases {
+		if _, got := p.ShouldRetry(c.api, 1, 0, c.err); got != c.want {
+			t.Errorf("%s %v: retry = %v, want %v", c.api, c.err, got, c.want)
+		}
+	}
+}
+
+func TestDefaultRetryPolicyLimits(t *testing.T) {
+	p := NewDefaultRetryPolicy()
+	err := &OTSServiceError{Status: 503, Code: ErrorCodeServerBusy}
+	if _, ok := p.ShouldRetry("GetRow", p.MaxAttempts, 0, err); ok {
+		t.Errorf("should stop after MaxAttempts")
+	}
+	if _, ok := p.ShouldRetry("GetRow", 1, p.MaxElapsedTime, err); ok {
+		t.Errorf("should stop after MaxElapsedTime")
+	}
+	for attempt := 1; attempt < 10; attempt++ {
+		interval := float64(p.InitialInterval) * float64(int(1)<<uint(attempt-1))
+		if interval > float64(p.MaxInterval) {
+			interval = float64(p.MaxInterval)
+		}
+		d := p.backoff(attempt)
+		if d < time.Duration(interval/2) || d >= time.Duration(interval) {
+			t.Errorf("attempt %d: backoff %v out of [%v, %v)", attempt, d, time.Duration(interval/2), time.Duration(interval))
+		}
+	}
+	if _, ok := (NoRetryPolicy{}).ShouldRetry("GetRow", 1, 0, err); ok {
+		t.Errorf("NoRetryPolicy should never retry")
+	}
+}
+
+func TestDefaultRetryPolicyZeroValue(t *testing.T) {
+	p := &DefaultRetryPolicy{}
+	err := &OTSServiceError{Status: 503, Code: ErrorCodeServerBusy}
+	for attempt := 1; attempt < DefaultMaxAttempts; attempt++ {
+		delay, ok := p.ShouldRetry("GetRow", attempt, 0, err)
+		interval := DefaultInitialInterval << uint(attempt-1)
+		if !ok || delay < interval/2 || delay >= interval {
+			t.Errorf("attempt %d: ShouldRetry() = %v, %v, want a backoff in [%v, %v)", attempt, delay, ok, interval/2, interval)
+		}
+	}
+	if _, ok := p.ShouldRetry("GetRow", DefaultMaxAttempts, 0, err); ok {
+		t.Errorf("should stop after DefaultMaxAttempts")
+	}
+	if d := p.backoff(20); d >= DefaultMaxInterval {
+		t.Errorf("backoff %v, want capped by DefaultMaxInterval", d)
+	}
+
+	p.InitialInterval = -1
+	if delay, ok := p.ShouldRetry("GetRow", 1, 0, err); !ok || delay != 0 {
+		t.Errorf("ShouldRetry() = %v, %v, want an immediate retry", delay, ok)
+	}
+}
+
+func TestSleepCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sleep(ctx, time.Hour) {
+		t.Errorf("sleep should return false when ctx is canceled")
+	}
+	if !sleep(context.Background(), time.Millisecond) {
+		t.Errorf("sleep should return true after the delay")
+	}
+}
+
+func TestNewClientRetryOptIn(t *testing.T) {
+	if c := NewClient("http://localhost", "id", "key", "inst"); c.RetryPolicy != nil {
+		t.Errorf("retries should be opt-in")
+	}
+}
+
+func TestClientRetriesThrottledWrite(t *testing.T) {
+	calls := 0
+	fail := func(calls int) bool { return calls < 3 }
+	code := ErrorCodeNotEnoughCapacityUnit
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		calls++
+		if fail(calls) {
+			return fakeError(503, code)
+		}
+		return 200, &protobuf.PutRowResponse{Consumed: consumed(0, 1)}
+	})
+	policy := NewDefaultRetryPolicy()
+	policy.InitialInterval = -1
+	client.RetryPolicy = policy
+
+	ignore := &Condition{RowExistence: RowExistenceExpectationIgnore}
+	pk := NewPrimaryKey().Add("uid", 1)
+	if _, err := client.PutRow("users", ignore, pk, map[string]interface{}{"age": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("attempts = %d, want 3", calls)
+	}
+
+	calls = 0
+	fail = func(int) bool { return true }
+	code = ErrorCodeInternalServerError
+	if _, err := client.PutRow("users", ignore, pk, nil); ErrorCode(err) != code {
+		t.Fatalf("err = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("non-idempotent write retried %d times", calls-1)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"fmt"
+)
+
+// NormalizePrimaryKey 按表结构定义的顺序重排主键，并检查是否缺少主键列、包含多余的列以及列值类型是否与定义一致。
+// allowINF 为true时允许使用INFMin和INFMax，仅用于GetRange
+func (tm *TableMeta) NormalizePrimaryKey(primaryKey PrimaryKey, allowINF bool) (PrimaryKey, error) {
+	columns := make(map[string]*Column, len(primaryKey))
+	for _, col := range primaryKey {
+		if _, ok := columns[col.Name]; ok {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Duplicate primary key column %s for table %s", col.Name, tm.TableName)}
+		}
+		columns[col.Name] = col
+	}
+
+	normalized := make(PrimaryKey, len(tm.PrimaryKey))
+	for i, cs := range tm.PrimaryKey {
+		col, ok := columns[cs.Name]
+		if !ok {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Primary key column %s is missing for table %s", cs.Name, tm.TableName)}
+		}
+		delete(columns, cs.Name)
+		if col.Value == nil {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Unsupported value type of primary key column %s for table %s, %s expected", cs.Name, tm.TableName, cs.Type)}
+		}
+		switch col.Value.Type {
+		case ColumnTypeINFMin, ColumnTypeINFMax:
+			if !allowINF {
+				return nil, &OTSClientError{Message: fmt.Sprintf("%s is only allowed in GetRange, primary key column %s for table %s", col.Value.Type, cs.Name, tm.TableName)}
+			}
+		case cs.Type:
+		default:
+			return nil, &OTSClientError{Message: fmt.Sprintf("Type of primary key column %s for table %s is %s, %s expected", cs.Name, tm.TableName, col.Value.Type, cs.Type)}
+		}
+		normalized[i] = col
+	}
+
+	for _, col := range primaryKey {
+		if _, ok := columns[col.Name]; ok {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Unknown primary key column %s for table %s", col.Name, tm.TableName)}
+		}
+	}
+	return normalized, nil
+}
+
+// InvalidateTableMeta 清除缓存的表结构，表被删除或重建后应调用此方法
+func (c *Client) InvalidateTableMeta(name string) {
+	c.metaLock.Lock()
+	delete(c.tableMetas, name)
+	c.metaLock.Unlock()
+}
+
+func (c *Client) cacheTableMeta(tm *TableMeta) {
+	c.metaLock.Lock()
+	c.tableMetas[tm.TableName] = tm
+	c.metaLock.Unlock()
+}
+
+// tableMeta 返回表结构，缓存中不存在时通过DescribeTable获取
+func (c *Client) tableMeta(ctx context.Context, name string) (*TableMeta, error) {
+	c.metaLock.RLock()
+	tm, ok := c.tableMetas[name]
+	c.metaLock.RUnlock()
+	if ok {
+		return tm, nil
+	}
+	tm, _, err := c.DescribeTableWithContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return tm, nil
+}
+
+func (c *Client) normalizePrimaryKey(ctx context.Context, name string, primaryKey PrimaryKey, allowINF bool) (PrimaryKey, error) {
+	if !c.CheckPrimaryKey {
+		return primaryKey, nil
+	}
+	tm, err := c.tableMeta(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return tm.NormalizePrimaryKey(primaryKey, allowINF)
+}
+
+func (c *Client) normalizeBatchGetRowItems(ctx context.Context, items map[string]BatchGetRowItem) (map[string]BatchGetRowItem, error) {
+	if !c.CheckPrimaryKey {
+		return items, nil
+	}
+	normalized := make(map[string]BatchGetRowItem, len(items))
+	for name, item := range items {
+		pks := make([]PrimaryKey, len(item.PrimaryKeys))
+		for i, pk := range item.PrimaryKeys {
+			npk, err := c.normalizePrimaryKey(ctx, name, pk, false)
+			if err != nil {
+				return nil, err
+			}
+			pks[i] = npk
+		}
+		normalized[name] = BatchGetRowItem{
+			PrimaryKeys: pks,
+			ColumnNames: item.ColumnNames,
+		}
+	}
+	return normalized, nil
+}
+
+func (c *Client) normalizeBatchWriteRowItems(ctx context.Context, items map[string]BatchWriteRowItem) (map[string]BatchWriteRowItem, error) {
+	if !c.CheckPrimaryKey {
+		return items, nil
+	}
+	normalized := make(map[string]BatchWriteRowItem, len(items))
+	for name, item := range items {
+		nitem := BatchWriteRowItem{
+			PutRows:    make([]*PutRowInBatchWriteRowItem, len(item.PutRows)),
+			UpdateRows: make([]*UpdateRowInBatchWriteRowItem, len(item.UpdateRows)),
+			DeleteRows: make([]*DeleteRowInBatchWriteRowItem, len(item.DeleteRows)),
+		}
+		for i, row := range item.PutRows {
+			pk, err := c.normalizePrimaryKey(ctx, name, row.PrimaryKey, false)
+			if err != nil {
+				return nil, err
+			}
+			nrow := *row
+			nrow.PrimaryKey = pk
+			nitem.PutRows[i] = &nrow
+		}
+		for i, row := range item.UpdateRows {
+			pk, err := c.normalizePrimaryKey(ctx, name, row.PrimaryKey, false)
+			if err != nil {
+				return nil, err
+			}
+			nrow := *row
+			nrow.PrimaryKey = pk
+			nitem.UpdateRows[i] = &nrow
+		}
+		for i, row := range item.DeleteRows {
+			pk, err := c.normalizePrimaryKey(ctx, name, row.PrimaryKey, false)
+			if err != nil {
+				return nil, err
+			}
+			nrow := *row
+			nrow.PrimaryKey = pk
+			nitem.DeleteRows[i] = &nrow
+		}
+		normalized[name] = nitem
+	}
+	return normalized, nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestNormalizePrimaryKey(t *testing.T) {
+	tm := &TableMeta{
+		TableName: "users",
+		PrimaryKey: []*ColumnSchema{
+			{Name: "gid", Type: ColumnTypeInteger},
+			{Name: "uid", Type: ColumnTypeString},
+		},
+	}
+	tests := []struct {
+		name     string
+		pk       PrimaryKey
+		allowINF bool
+		want     string
+		err      string
+	}{
+		{"ordered", NewPrimaryKey().Add("gid", 1).Add("uid", "a"), false, "[gid uid]", ""},
+		{"reordered", NewPrimaryKey().Add("uid", "a").Add("gid", 1), false, "[gid uid]", ""},
+		{"missing", NewPrimaryKey().Add("gid", 1), false, "", "uid is missing"},
+		{"unknown", NewPrimaryKey().Add("gid", 1).Add("uid", "a").Add("x", 1), false, "", "Unknown primary key column x"},
+		{"duplicate", NewPrimaryKey().Add("gid", 1).Add("gid", 2).Add("uid", "a"), false, "", "Duplicate primary key column gid"},
+		{"wrong type", NewPrimaryKey().Add("gid", "1").Add("uid", "a"), false, "", "is STRING, INTEGER expected"},
+		{"unsupported type", NewPrimaryKey().Add("gid", struct{}{}).Add("uid", "a"), false, "", "Unsupported value type"},
+		{"INF not allowed", NewPrimaryKey().Add("gid", 1).Add("uid", INFMin), false, "", "only allowed in GetRange"},
+		{"INF allowed", NewPrimaryKey().Add("uid", INFMax).Add("gid", INFMin), true, "[gid uid]", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tm.NormalizePrimaryKey(tt.pk, tt.allowINF)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("NormalizePrimaryKey() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make([]string, len(got))
+			for i, col := range got {
+				names[i] = col.Name
+			}
+			if fmt.Sprint(names) != tt.want {
+				t.Errorf("NormalizePrimaryKey() = %v, want %s", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPrimaryKey(t *testing.T) {
+	describes := 0
+	var putKeys []string
+	client := newFakeClient(t, func(apiName string, body []byte) (int, proto.Message) {
+		switch apiName {
+		case "DescribeTable":
+			describes++
+			return 200, &protobuf.DescribeTableResponse{
+				TableMeta: &protobuf.TableMeta{
+					TableName: proto.String("users"),
+					PrimaryKey: []*protobuf.ColumnSchema{
+						{Name: proto.String("gid"), Type: protobuf.ColumnType_INTEGER.Enum()},
+						{Name: proto.String("uid"), Type: protobuf.ColumnType_INTEGER.Enum()},
+					},
+				},
+				ReservedThroughputDetails: &protobuf.ReservedThroughputDetails{
+					CapacityUnit:           &protobuf.CapacityUnit{Read: proto.Int32(10), Write: proto.Int32(10)},
+					LastIncreaseTime:       proto.Int64(0),
+					NumberOfDecreasesToday: proto.Int32(0),
+				},
+			}
+		case "PutRow":
+			req := &protobuf.PutRowRequest{}
+			proto.Unmarshal(body, req)
+			var names []string
+			for _, col := range req.GetPrimaryKey() {
+				names = append(names, col.GetName())
+			}
+			putKeys = append(putKeys, fmt.Sprint(names))
+			return 200, &protobuf.PutRowResponse{Consumed: consumed(0, 1)}
+		}
+		return fakeError(400, "OTSParameterInvalid")
+	})
+	client.CheckPrimaryKey = true
+
+	ignore := &Condition{RowExistence: RowExistenceExpectationIgnore}
+	reversed := NewPrimaryKey().Add("uid", 2).Add("gid", 1)
+	for i := 0; i < 2; i++ {
+		if _, err := client.PutRow("users", ignore, reversed, map[string]interface{}{"age": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(putKeys) != "[[gid uid] [gid uid]]" {
+		t.Errorf("request primary keys = %v, want reordered as the table schema", putKeys)
+	}
+
+	if _, err := client.PutRow("users", ignore, NewPrimaryKey().Add("gid", 1), nil); err == nil || !strings.Contains(err.Error(), "uid is missing") {
+		t.Errorf("PutRow() with missing primary key column = %v", err)
+	}
+	if len(putKeys) != 2 {
+		t.Errorf("invalid primary key sent in %d requests", len(putKeys)-2)
+	}
+	if describes != 1 {
+		t.Errorf("DescribeTable called %d times, want the table meta cached after 1", describes)
+	}
+}
@@ -0,0 +1,319 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// MaxBatchWriteRowCount 为OTS允许的单次BatchWriteRow请求的最大行数
+const MaxBatchWriteRowCount = 200
+
+// Table 是以结构体T读写单张表的句柄，T的字段通过ots标签映射为列，参见Marshal。
+// 第一次访问表时会根据DescribeTable校验T中主键列的顺序和类型
+// 示例:
+//
+//	users := gots.NewTable[User](client, "users")
+//	err := users.Put(ctx, User{GID: 1, UID: 101, Name: "gots"}, nil)
+//	user, found, err := users.Get(ctx, User{GID: 1, UID: 101})
+type Table[T any] struct {
+	client *Client
+	name   string
+	mu     sync.Mutex
+	codec  *structCodec
+}
+
+// NewTable 返回表name的句柄
+func NewTable[T any](client *Client, name string) *Table[T] {
+	return &Table[T]{
+		client: client,
+		name:   name,
+	}
+}
+
+// Name 返回表名
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+func columnTypeOfKind(kind reflect.Kind) ColumnType {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ColumnTypeInteger
+	case reflect.Float32, reflect.Float64:
+		return ColumnTypeDouble
+	case reflect.Bool:
+		return ColumnTypeBoolean
+	case reflect.String:
+		return ColumnTypeString
+	}
+	return ColumnTypeBinary
+}
+
+// init 解析T的结构并与表结构比较主键，校验失败时下次访问会重新校验
+func (t *Table[T]) init(ctx context.Context) (*structCodec, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.codec != nil {
+		return t.codec, nil
+	}
+	c, err := codecOf(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+	tm, err := t.client.tableMeta(ctx, t.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.primaryKey) != len(tm.PrimaryKey) {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Table %s has %d primary key columns, but %d defined in struct", t.name, len(tm.PrimaryKey), len(c.primaryKey))}
+	}
+	for i, cs := range tm.PrimaryKey {
+		f := c.primaryKey[i]
+		if f.name != cs.Name {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Primary key column %d of table %s is %s, but %s defined in struct", i, t.name, cs.Name, f.name)}
+		}
+		if ct := columnTypeOfKind(f.kind); ct != cs.Type {
+			return nil, &OTSClientError{Message: fmt.Sprintf("Type of primary key column %s of table %s is %s, but %s defined in struct", cs.Name, t.name, cs.Type, ct)}
+		}
+	}
+	t.codec = c
+	return c, nil
+}
+
+func conditionOrIgnore(condition *Condition) *Condition {
+	if condition == nil {
+		return &Condition{RowExistence: RowExistenceExpectationIgnore}
+	}
+	return condition
+}
+
+func rowExists(row *Row) bool {
+	return row != nil && (len(row.PrimaryKeyColumns) > 0 || len(row.AttributeColumns) > 0)
+}
+
+// Get 读取主键与key相同的行，行不存在时found为false
+func (t *Table[T]) Get(ctx context.Context, key T, columnNames ...string) (value T, found bool, err error) {
+	if _, err = t.init(ctx); err != nil {
+		return value, false, err
+	}
+	pk, err := MarshalPrimaryKey(&key)
+	if err != nil {
+		return value, false, err
+	}
+	resp, err := t.client.GetRowWithContext(ctx, t.name, pk, columnNames)
+	if err != nil {
+		return value, false, err
+	}
+	if !rowExists(resp.Row) {
+		return value, false, nil
+	}
+	if err = Unmarshal(resp.Row, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// Put 写入一行，condition为nil时忽略行是否存在
+func (t *Table[T]) Put(ctx context.Context, value T, condition *Condition) error {
+	if _, err := t.init(ctx); err != nil {
+		return err
+	}
+	pk, columns, err := Marshal(&value)
+	if err != nil {
+		return err
+	}
+	_, err = t.client.PutRowWithContext(ctx, t.name, conditionOrIgnore(condition), pk, columns)
+	return err
+}
+
+// Update 更新一行，值为nil且没有omitempty选项的指针字段对应的列会被删除，参见MarshalUpdate
+func (t *Table[T]) Update(ctx context.Context, value T, condition *Condition) error {
+	if _, err := t.init(ctx); err != nil {
+		return err
+	}
+	pk, columnsPut, columnsDelete, err := MarshalUpdate(&value)
+	if err != nil {
+		return err
+	}
+	_, err = t.client.UpdateRowWithContext(ctx, t.name, conditionOrIgnore(condition), pk, columnsPut, columnsDelete)
+	return err
+}
+
+// Delete 删除主键与key相同的行
+func (t *Table[T]) Delete(ctx context.Context, key T, condition *Condition) error {
+	if _, err := t.init(ctx); err != nil {
+		return err
+	}
+	pk, err := MarshalPrimaryKey(&key)
+	if err != nil {
+		return err
+	}
+	_, err = t.client.DeleteRowWithContext(ctx, t.name, conditionOrIgnore(condition), pk)
+	return err
+}
+
+// BatchGet 批量读取多行，结果与keys按下标一一对应，行不存在时对应的结果为nil。
+// 任意一行读取失败时返回该行的错误
+func (t *Table[T]) BatchGet(ctx context.Context, keys []T, columnNames ...string) ([]*T, error) {
+	if _, err := t.init(ctx); err != nil {
+		return nil, err
+	}
+	pks := make([]PrimaryKey, len(keys))
+	for i := range keys {
+		pk, err := MarshalPrimaryKey(&keys[i])
+		if err != nil {
+			return nil, err
+		}
+		pks[i] = pk
+	}
+	items := map[string]BatchGetRowItem{
+		t.name: {
+			PrimaryKeys: pks,
+			ColumnNames: columnNames,
+		},
+	}
+	resp, err := t.client.BatchGetRowWithContext(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	table := resp.Table(t.name)
+	if table == nil || len(table.Rows) != len(keys) {
+		return nil, &OTSClientError{Message: fmt.Sprintf("Rows count mismatch for table %s in BatchGetRow response", t.name)}
+	}
+	values := make([]*T, len(keys))
+	for i, row := range table.Rows {
+		if !row.IsOk {
+			return nil, row.Error
+		}
+		if !rowExists(row.Row) {
+			continue
+		}
+		value := new(T)
+		if err := Unmarshal(row.Row, value); err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// BatchPut 批量写入多行，每MaxBatchWriteRowCount行发送一次请求，忽略行是否存在。
+// 任意一行写入失败时返回该行的错误，此前已经成功的行不会回滚
+func (t *Table[T]) BatchPut(ctx context.Context, values []T) error {
+	if _, err := t.init(ctx); err != nil {
+		return err
+	}
+	for start := 0; start < len(values); start += MaxBatchWriteRowCount {
+		end := start + MaxBatchWriteRowCount
+		if end > len(values) {
+			end = len(values)
+		}
+		rows := make([]*PutRowInBatchWriteRowItem, 0, end-start)
+		for i := start; i < end; i++ {
+			pk, columns, err := Marshal(&values[i])
+			if err != nil {
+				return err
+			}
+			rows = append(rows, &PutRowInBatchWriteRowItem{
+				Condition:  conditionOrIgnore(nil),
+				PrimaryKey: pk,
+				Columns:    columns,
+			})
+		}
+		items := map[string]BatchWriteRowItem{
+			t.name: {PutRows: rows},
+		}
+		resp, err := t.client.BatchWriteRowWithContext(ctx, items)
+		if err != nil {
+			return err
+		}
+		for _, row := range resp.Table(t.name).PutRows {
+			if !row.IsOk {
+				return row.Error
+			}
+		}
+	}
+	return nil
+}
+
+// Scan 返回按主键顺序遍历[start, end)范围的迭代器，start或end为nil时分别表示从表头开始或读取到表尾
+func (t *Table[T]) Scan(ctx context.Context, start, end PrimaryKey, columnNames ...string) *TableIterator[T] {
+	c, err := t.init(ctx)
+	if err != nil {
+		return &TableIterator[T]{err: err}
+	}
+	if start == nil {
+		start = infPrimaryKey(c, INFMin)
+	}
+	if end == nil {
+		end = infPrimaryKey(c, INFMax)
+	}
+	req := &GetRangeRequest{
+		TableName:                t.name,
+		Direction:                DirectionForward,
+		InclusiveStartPrimaryKey: start,
+		ExclusiveEndPrimaryKey:   end,
+		ColumnNames:              columnNames,
+	}
+	return &TableIterator[T]{it: t.client.XGetRangeWithContext(ctx, req, nil)}
+}
+
+func infPrimaryKey(c *structCodec, inf *ColumnValue) PrimaryKey {
+	pk := NewPrimaryKey()
+	for _, f := range c.primaryKey {
+		pk = pk.Add(f.name, inf)
+	}
+	return pk
+}
+
+// TableIterator 用于遍历Table.Scan返回的行
+type TableIterator[T any] struct {
+	it    *RangeIterator
+	value T
+	err   error
+}
+
+// Next 将迭代器移动到下一行，没有更多数据或出错时返回false
+func (ti *TableIterator[T]) Next() bool {
+	if ti.err != nil || !ti.it.Next() {
+		return false
+	}
+	var value T
+	if err := Unmarshal(ti.it.Row(), &value); err != nil {
+		ti.err = err
+		return false
+	}
+	ti.value = value
+	return true
+}
+
+// Value 返回当前行
+func (ti *TableIterator[T]) Value() T {
+	return ti.value
+}
+
+// Err 返回迭代过程中发生的错误
+func (ti *TableIterator[T]) Err() error {
+	if ti.err != nil {
+		return ti.err
+	}
+	return ti.it.Err()
+}
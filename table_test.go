@@ -0,0 +1,395 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Xuyuanp/gots/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+type tableUser struct {
+	GID  int64  `ots:"gid,pk"`
+	UID  int64  `ots:"uid,pk"`
+	Name string `ots:"name,omitempty"`
+	Age  *int64 `ots:"age"`
+}
+
+// fakeTableStore 是内存中的OTS服务，所有表的主键都是gid和uid两个整型列
+type fakeTableStore struct {
+	mu       sync.Mutex
+	pageSize int
+	tables   map[string]map[[2]int64][]*protobuf.Column
+	counts   map[string]int
+}
+
+func newFakeTableStore(tables ...string) *fakeTableStore {
+	s := &fakeTableStore{
+		pageSize: 100,
+		tables:   make(map[string]map[[2]int64][]*protobuf.Column),
+		counts:   make(map[string]int),
+	}
+	for _, name := range tables {
+		s.createTable(name)
+	}
+	return s
+}
+
+func (s *fakeTableStore) createTable(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[name] = make(map[[2]int64][]*protobuf.Column)
+}
+
+func (s *fakeTableStore) count(apiName string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[apiName]
+}
+
+func storeKey(pk []*protobuf.Column) [2]int64 {
+	return [2]int64{pk[0].GetValue().GetVInt(), pk[1].GetValue().GetVInt()}
+}
+
+func keyColumns(key [2]int64) []*protobuf.Column {
+	return []*protobuf.Column{
+		{Name: proto.String("gid"), Value: &protobuf.ColumnValue{Type: protobuf.ColumnType_INTEGER.Enum(), VInt: proto.Int64(key[0])}},
+		{Name: proto.String("uid"), Value: &protobuf.ColumnValue{Type: protobuf.ColumnType_INTEGER.Enum(), VInt: proto.Int64(key[1])}},
+	}
+}
+
+// compareBound 比较key与范围边界bound，边界中的列可以是INF_MIN或INF_MAX
+func compareBound(key [2]int64, bound []*protobuf.Column) int {
+	for i, col := range bound {
+		switch v := col.GetValue(); v.GetType() {
+		case protobuf.ColumnType_INF_MIN:
+			return 1
+		case protobuf.ColumnType_INF_MAX:
+			return -1
+		default:
+			if key[i] != v.GetVInt() {
+				if key[i] < v.GetVInt() {
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// write 按condition用update的结果替换一行的属性列，update返回nil时删除该行
+func (s *fakeTableStore) write(table map[[2]int64][]*protobuf.Column, condition *protobuf.Condition, pk []*protobuf.Column, update func(old []*protobuf.Column) []*protobuf.Column) (int, proto.Message) {
+	key := storeKey(pk)
+	old, exists := table[key]
+	switch condition.GetRowExistence() {
+	case protobuf.RowExistenceExpectation_EXPECT_EXIST:
+		if !exists {
+			return fakeError(403, "OTSConditionCheckFail")
+		}
+	case protobuf.RowExistenceExpectation_EXPECT_NOT_EXIST:
+		if exists {
+			return fakeError(403, "OTSConditionCheckFail")
+		}
+	}
+	if columns := update(old); columns != nil {
+		table[key] = columns
+	} else {
+		delete(table, key)
+	}
+	return 200, nil
+}
+
+func applyUpdates(old []*protobuf.Column, updates []*protobuf.ColumnUpdate) []*protobuf.Column {
+	columns := make(map[string]*protobuf.ColumnValue)
+	for _, col := range old {
+		columns[col.GetName()] = col.GetValue()
+	}
+	for _, u := range updates {
+		if u.GetType() == protobuf.OperationType_DELETE {
+			delete(columns, u.GetName())
+		} else {
+			columns[u.GetName()] = u.GetValue()
+		}
+	}
+	result := []*protobuf.Column{}
+	for name, value := range columns {
+		result = append(result, &protobuf.Column{Name: proto.String(name), Value: value})
+	}
+	return result
+}
+
+func (s *fakeTableStore) handle(apiName string, body []byte) (int, proto.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[apiName]++
+	switch apiName {
+	case "DescribeTable":
+		req := &protobuf.DescribeTableRequest{}
+		proto.Unmarshal(body, req)
+		if _, ok := s.tables[req.GetTableName()]; !ok {
+			return fakeError(404, "OTSObjectNotExist")
+		}
+		return 200, &protobuf.DescribeTableResponse{
+			TableMeta: &protobuf.TableMeta{
+				TableName: req.TableName,
+				PrimaryKey: []*protobuf.ColumnSchema{
+					{Name: proto.String("gid"), Type: protobuf.ColumnType_INTEGER.Enum()},
+					{Name: proto.String("uid"), Type: protobuf.ColumnType_INTEGER.Enum()},
+				},
+			},
+			ReservedThroughputDetails: &protobuf.ReservedThroughputDetails{
+				CapacityUnit:           &protobuf.CapacityUnit{Read: proto.Int32(10), Write: proto.Int32(10)},
+				LastIncreaseTime:       proto.Int64(0),
+				NumberOfDecreasesToday: proto.Int32(0),
+			},
+		}
+	case "GetRow":
+		req := &protobuf.GetRowRequest{}
+		proto.Unmarshal(body, req)
+		row := &protobuf.Row{}
+		if columns, ok := s.tables[req.GetTableName()][storeKey(req.GetPrimaryKey())]; ok {
+			row.PrimaryKeyColumns = req.GetPrimaryKey()
+			row.AttributeColumns = columns
+		}
+		return 200, &protobuf.GetRowResponse{Consumed: consumed(1, 0), Row: row}
+	case "PutRow":
+		req := &protobuf.PutRowRequest{}
+		proto.Unmarshal(body, req)
+		status, msg := s.write(s.tables[req.GetTableName()], req.GetCondition(), req.GetPrimaryKey(), func([]*protobuf.Column) []*protobuf.Column {
+			return append([]*protobuf.Column{}, req.GetAttributeColumns()...)
+		})
+		if status != 200 {
+			return status, msg
+		}
+		return 200, &protobuf.PutRowResponse{Consumed: consumed(0, 1)}
+	case "UpdateRow":
+		req := &protobuf.UpdateRowRequest{}
+		proto.Unmarshal(body, req)
+		status, msg := s.write(s.tables[req.GetTableName()], req.GetCondition(), req.GetPrimaryKey(), func(old []*protobuf.Column) []*protobuf.Column {
+			return applyUpdates(old, req.GetAttributeColumns())
+		})
+		if status != 200 {
+			return status, msg
+		}
+		return 200, &protobuf.UpdateRowResponse{Consumed: consumed(0, 1)}
+	case "DeleteRow":
+		req := &protobuf.DeleteRowRequest{}
+		proto.Unmarshal(body, req)
+		status, msg := s.write(s.tables[req.GetTableName()], req.GetCondition(), req.GetPrimaryKey(), func([]*protobuf.Column) []*protobuf.Column {
+			return nil
+		})
+		if status != 200 {
+			return status, msg
+		}
+		return 200, &protobuf.DeleteRowResponse{Consumed: consumed(0, 1)}
+	case "BatchWriteRow":
+		req := &protobuf.BatchWriteRowRequest{}
+		proto.Unmarshal(body, req)
+		resp := &protobuf.BatchWriteRowResponse{}
+		for _, t := range req.GetTables() {
+			result := &protobuf.TableInBatchWriteRowResponse{TableName: t.TableName}
+			for _, row := range t.GetPutRows() {
+				s.tables[t.GetTableName()][storeKey(row.GetPrimaryKey())] = row.GetAttributeColumns()
+				result.PutRows = append(result.PutRows, &protobuf.RowInBatchWriteRowResponse{IsOk: proto.Bool(true), Consumed: consumed(0, 1)})
+			}
+			resp.Tables = append(resp.Tables, result)
+		}
+		return 200, resp
+	case "BatchGetRow":
+		req := &protobuf.BatchGetRowRequest{}
+		proto.Unmarshal(body, req)
+		resp := &protobuf.BatchGetRowResponse{}
+		for _, t := range req.GetTables() {
+			result := &protobuf.TableInBatchGetRowResponse{TableName: t.TableName}
+			for _, row := range t.GetRows() {
+				r := &protobuf.Row{}
+				if columns, ok := s.tables[t.GetTableName()][storeKey(row.GetPrimaryKey())]; ok {
+					r.PrimaryKeyColumns = row.GetPrimaryKey()
+					r.AttributeColumns = columns
+				}
+				result.Rows = append(result.Rows, &protobuf.RowInBatchGetRowResponse{IsOk: proto.Bool(true), Consumed: consumed(1, 0), Row: r})
+			}
+			resp.Tables = append(resp.Tables, result)
+		}
+		return 200, resp
+	case "GetRange":
+		req := &protobuf.GetRangeRequest{}
+		proto.Unmarshal(body, req)
+		var keys [][2]int64
+		for key := range s.tables[req.GetTableName()] {
+			if compareBound(key, req.GetInclusiveStartPrimaryKey()) >= 0 && compareBound(key, req.GetExclusiveEndPrimaryKey()) < 0 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+		})
+		resp := &protobuf.GetRangeResponse{Consumed: consumed(1, 0)}
+		if len(keys) > s.pageSize {
+			resp.NextStartPrimaryKey = keyColumns(keys[s.pageSize])
+			keys = keys[:s.pageSize]
+		}
+		for _, key := range keys {
+			resp.Rows = append(resp.Rows, &protobuf.Row{PrimaryKeyColumns: keyColumns(key), AttributeColumns: s.tables[req.GetTableName()][key]})
+		}
+		return 200, resp
+	}
+	return fakeError(400, "OTSParameterInvalid")
+}
+
+func TestTable(t *testing.T) {
+	store := newFakeTableStore("users")
+	client := newFakeClient(t, store.handle)
+	users := NewTable[tableUser](client, "users")
+	ctx := context.Background()
+
+	age := int64(30)
+	if err := users.Put(ctx, tableUser{GID: 1, UID: 1, Name: "a", Age: &age}, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, found, err := users.Get(ctx, tableUser{GID: 1, UID: 1})
+	if err != nil || !found {
+		t.Fatalf("Get() = %v, %v", found, err)
+	}
+	if got.Name != "a" || got.Age == nil || *got.Age != 30 {
+		t.Errorf("Get() = %+v, want the put row", got)
+	}
+	if _, found, err := users.Get(ctx, tableUser{GID: 1, UID: 2}); err != nil || found {
+		t.Errorf("Get() missing row = %v, %v, want not found", found, err)
+	}
+
+	// Age为nil时Update删除age列，Name为空时保留原值
+	if err := users.Update(ctx, tableUser{GID: 1, UID: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, _, _ = users.Get(ctx, tableUser{GID: 1, UID: 1})
+	if got.Name != "a" || got.Age != nil {
+		t.Errorf("Get() after Update = %+v, want name kept and age deleted", got)
+	}
+
+	expectExist := &Condition{RowExistence: RowExistenceExpectationExpectExist}
+	if err := users.Delete(ctx, tableUser{GID: 1, UID: 1}, expectExist); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(ctx, tableUser{GID: 1, UID: 1}, expectExist); ErrorCode(err) != ErrorCodeConditionCheckFail {
+		t.Errorf("Delete() missing row = %v, want %s", err, ErrorCodeConditionCheckFail)
+	}
+	if n := store.count("DescribeTable"); n != 1 {
+		t.Errorf("DescribeTable requests = %d, want the schema checked once", n)
+	}
+}
+
+func TestTableBatch(t *testing.T) {
+	store := newFakeTableStore("users")
+	client := newFakeClient(t, store.handle)
+	users := NewTable[tableUser](client, "users")
+	ctx := context.Background()
+
+	values := make([]tableUser, MaxBatchWriteRowCount+50)
+	for i := range values {
+		values[i] = tableUser{GID: 1, UID: int64(i + 1), Name: "user"}
+	}
+	if err := users.BatchPut(ctx, values); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.count("BatchWriteRow"); n != 2 {
+		t.Errorf("BatchWriteRow requests = %d, want 2", n)
+	}
+
+	got, err := users.BatchGet(ctx, []tableUser{{GID: 1, UID: 3}, {GID: 2, UID: 1}, {GID: 1, UID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] == nil || got[0].UID != 3 || got[1] != nil || got[2] == nil || got[2].UID != 1 {
+		t.Errorf("BatchGet() = %v, want rows in key order and nil for the missing row", got)
+	}
+
+	it := users.Scan(ctx, nil, nil)
+	n := 0
+	for it.Next() {
+		n++
+		if v := it.Value(); v.UID != int64(n) || v.Name != "user" {
+			t.Fatalf("Scan() row %d = %+v", n, v)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(values) {
+		t.Errorf("Scan() returned %d rows, want %d", n, len(values))
+	}
+	if n := store.count("GetRange"); n != 3 {
+		t.Errorf("GetRange requests = %d, want the scan to follow 3 pages", n)
+	}
+}
+
+func TestTableSchemaMismatch(t *testing.T) {
+	store := newFakeTableStore("users")
+	client := newFakeClient(t, store.handle)
+	ctx := context.Background()
+
+	type reordered struct {
+		UID int64 `ots:"uid,pk"`
+		GID int64 `ots:"gid,pk"`
+	}
+	type wrongType struct {
+		GID int64  `ots:"gid,pk"`
+		UID string `ots:"uid,pk"`
+	}
+	type missing struct {
+		GID int64 `ots:"gid,pk"`
+	}
+	tests := []struct {
+		name string
+		put  func() error
+		err  string
+	}{
+		{"reordered", func() error { return NewTable[reordered](client, "users").Put(ctx, reordered{}, nil) }, "Primary key column 0 of table users is gid, but uid defined"},
+		{"wrong type", func() error { return NewTable[wrongType](client, "users").Put(ctx, wrongType{}, nil) }, "is INTEGER, but STRING defined"},
+		{"missing", func() error { return NewTable[missing](client, "users").Put(ctx, missing{}, nil) }, "has 2 primary key columns, but 1 defined"},
+		{"no table", func() error { return NewTable[tableUser](client, "nope").Put(ctx, tableUser{}, nil) }, ErrorCodeObjectNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.put(); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Put() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+	if n := store.count("PutRow"); n != 0 {
+		t.Errorf("%d rows put with a mismatched schema", n)
+	}
+
+	// 校验失败不会被缓存，表创建后再次访问成功
+	users := NewTable[tableUser](client, "later")
+	if err := users.Put(ctx, tableUser{GID: 1, UID: 1}, nil); err == nil {
+		t.Fatal("Put() to a missing table succeeded")
+	}
+	store.createTable("later")
+	if err := users.Put(ctx, tableUser{GID: 1, UID: 1}, nil); err != nil {
+		t.Errorf("Put() after the table is created = %v", err)
+	}
+	if n := len(store.tables["later"]); n != 1 {
+		t.Errorf("rows in later = %d, want 1", n)
+	}
+}
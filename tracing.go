@@ -0,0 +1,93 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultTraceHeader 为默认传递调用方trace ID的请求头
+const DefaultTraceHeader = "X-Trace-Id"
+
+type traceIDKey struct{}
+
+// ContextWithTraceID 返回携带trace ID的context，使用该context的请求会通过Client.TraceHeader传递trace ID
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext 返回ctx中的trace ID，不存在时返回空字符串
+func TraceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// SpanInfo 描述一次API调用，包含其所有重试
+type SpanInfo struct {
+	APIName string
+	// TableNames 为请求涉及的表名，ListTable为空
+	TableNames []string
+	// TraceID 为调用方的trace ID，Tracer可以在StartSpan返回的context中通过ContextWithTraceID设置
+	TraceID string
+	Start   time.Time
+
+	// 以下字段在span结束时填充
+	Duration time.Duration
+	// Attempts 为发送请求的次数，大于1表示发生了重试
+	Attempts int
+	// RequestID 为最后一次尝试时服务端返回的x-ots-requestid
+	RequestID string
+	// StatusCode 为最后一次尝试的HTTP响应码
+	StatusCode int
+	// RowCount 为请求涉及的行数，含义同Invocation.RowCount
+	RowCount int
+	// Consumed 为各表消耗的读写能力单元，请求失败时为nil
+	Consumed map[string]*CapacityUnit
+}
+
+// end 使用最后一次尝试的结果填充span信息
+func (info *SpanInfo) end(inv *Invocation, err error) {
+	info.Duration = time.Since(info.Start)
+	if inv == nil {
+		return
+	}
+	info.Attempts = inv.Attempt
+	info.RequestID = inv.RequestID
+	info.StatusCode = inv.StatusCode
+	info.RowCount = inv.RowCount()
+	info.Consumed = inv.ConsumedCapacity()
+}
+
+// Tracer 在每次API调用开始时创建span，实现需要并发安全。
+// 返回的context会用于发送请求，可以携带调用方的span和trace ID。
+// 示例:
+//
+//	type tracer struct{}
+//
+//	func (tracer) StartSpan(ctx context.Context, info *gots.SpanInfo) (context.Context, gots.Span) {
+//		span := myTracer.Start(ctx, "ots."+info.APIName)
+//		return gots.ContextWithTraceID(ctx, span.TraceID()), mySpan{span}
+//	}
+type Tracer interface {
+	StartSpan(ctx context.Context, info *SpanInfo) (context.Context, Span)
+}
+
+// Span 在API调用结束时被调用，err为最终的错误
+type Span interface {
+	End(info *SpanInfo, err error)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2014 Xuyuan Pang <xuyuanp # gmail dot com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gots_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Xuyuanp/gots"
+)
+
+// recordTracer 记录所有结束的span，traceID不为空时为每次调用设置该trace ID
+type recordTracer struct {
+	traceID string
+	spans   []*gots.SpanInfo
+	errs    []error
+}
+
+type recordSpan struct {
+	tracer *recordTracer
+}
+
+func (tr *recordTracer) StartSpan(ctx context.Context, info *gots.SpanInfo) (context.Context, gots.Span) {
+	if tr.traceID != "" {
+		ctx = gots.ContextWithTraceID(ctx, tr.traceID)
+	}
+	return ctx, recordSpan{tr}
+}
+
+func (s recordSpan) End(info *gots.SpanInfo, err error) {
+	s.tracer.spans = append(s.tracer.spans, info)
+	s.tracer.errs = append(s.tracer.errs, err)
+}
+
+func TestTracerSpan(t *testing.T) {
+	client, _ := newTestClient(t)
+	createTestTable(t, client, "users", 10, 10)
+	policy := gots.NewDefaultRetryPolicy()
+	policy.InitialInterval = -1
+	client.RetryPolicy = policy
+	tracer := &recordTracer{}
+	client.Tracer = tracer
+
+	var requestID string
+	throttled := false
+	client.Interceptors = []gots.Interceptor{func(ctx context.Context, inv *gots.Invocation, next gots.Handler) error {
+		if !throttled {
+			throttled = true
+			return &gots.OTSServiceError{Status: 503, Code: gots.ErrorCodeNotEnoughCapacityUnit}
+		}
+		err := next(ctx, inv)
+		requestID = inv.RequestID
+		return err
+	}}
+	if _, err := client.PutRow("users", ignore, testPrimaryKey(1, 1), map[string]interface{}{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("spans = %d, want one span for all attempts", len(tracer.spans))
+	}
+	info := tracer.spans[0]
+	if info.APIName != "PutRow" || fmt.Sprint(info.TableNames) != "[users]" || info.RowCount != 1 {
+		t.Errorf("span = %+v", info)
+	}
+	if info.Attempts != 2 || info.StatusCode != 200 || info.RequestID != requestID || info.RequestID == "" {
+		t.Errorf("attempts = %d, status = %d, request id = %q, want the last attempt %q", info.Attempts, info.StatusCode, info.RequestID, requestID)
+	}
+	if cu := info.Consumed["users"]; cu == nil || cu.Write < 1 || info.Duration <= 0 || info.Start.IsZero() {
+		t.Errorf("consumed = %v, duration = %v", cu, info.Duration)
+	}
+	if tracer.errs[0] != nil {
+		t.Errorf("span error = %v", tracer.errs[0])
+	}
+
+	client.Interceptors = nil
+	tracer.spans, tracer.errs = nil, nil
+	_, _, err := client.DescribeTable("nope")
+	if gots.ErrorCode(err) != gots.ErrorCodeObjectNotExist {
+		t.Fatalf("DescribeTable() = %v", err)
+	}
+	info = tracer.spans[0]
+	if tracer.errs[0] != err || info.StatusCode != 404 || info.RequestID == "" || info.Consumed != nil {
+		t.Errorf("failed span = %+v, error = %v", info, tracer.errs[0])
+	}
+}
+
+func TestTraceHeader(t *testing.T) {
+	client, srv := newTestClient(t)
+	var headers []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get(gots.DefaultTraceHeader)+"|"+r.Header.Get("X-Request-Trace"))
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client.EndPoint = ts.URL
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := gots.ContextWithTraceID(context.Background(), "caller")
+
+	tracer := &recordTracer{}
+	client.Tracer = tracer
+	if _, err := client.ListTableWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Tracer返回的context中的trace ID优先于调用方的trace ID
+	tracer.traceID = "span"
+	if _, err := client.ListTableWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client.TraceHeader = "X-Request-Trace"
+	if _, err := client.ListTableWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client.TraceHeader = ""
+	if _, err := client.ListTableWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(headers); got != "[caller| span| |span |]" {
+		t.Errorf("trace headers = %s", got)
+	}
+	var traceIDs []string
+	for _, info := range tracer.spans {
+		traceIDs = append(traceIDs, info.TraceID)
+	}
+	if fmt.Sprint(traceIDs) != "[caller span span span]" {
+		t.Errorf("span trace IDs = %v", traceIDs)
+	}
+}